@@ -5,8 +5,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"net/url"
+	"strings"
+	"sync"
 
+	"github.com/ecoletibco/yukonquery/sqlodata"
 	"github.com/project-flogo/core/activity"
 	"github.com/project-flogo/core/data/metadata"
 )
@@ -33,11 +35,29 @@ type YukonQueryResponse struct {
 	Results []interface{} `json:"results"`
 }
 
+// DistinctServer and DistinctClient are the values of the distinct output,
+// reporting whether the duplicates of a select distinct query were removed by
+// the server or by the activity
+const (
+	DistinctServer = "server"
+	DistinctClient = "client"
+)
+
+// ConnectEager, ConnectLazy and ConnectBackground are the values of the
+// connectMode setting. Eager connects in New and fails when the server is not
+// available, lazy connects on the first execution and background connects in
+// the background, retrying until the server is available
+const (
+	ConnectEager      = "eager"
+	ConnectLazy       = "lazy"
+	ConnectBackground = "background"
+)
+
 type Activity struct {
-	settings        *Settings
-	client          *http.Client
-	connectionId    string
-	connectionToken string
+	settings *Settings
+	query    *sqlodata.Statement
+	conn     *connectionManager
+	release  sync.Once
 }
 
 func init() {
@@ -59,21 +79,48 @@ func New(ctx activity.InitContext) (activity.Activity, error) {
 		return nil, err
 	}
 
-	client, err := getHttpClient(20)
+	query, err := sqlodata.Parse(s.Query)
+	if err != nil {
+		return nil, err
+	}
+
+	mode := strings.ToLower(s.ConnectMode)
+	switch mode {
+	case "", ConnectEager, ConnectLazy, ConnectBackground:
+	default:
+		return nil, fmt.Errorf("invalid connectMode '%s', expected eager, lazy or background", s.ConnectMode)
+	}
+
+	err = checkConnectionSettings(s)
 	if err != nil {
 		return nil, err
 	}
 
-	connectionId, connectionToken, err := connect(client, s)
+	if s.TLSSkipVerify {
+		ctx.Logger().Warnf("TLS certificate verification is disabled for '%s', tlsSkipVerify must not be used in production", s.URL)
+	}
+
+	conn, err := acquireConnectionManager(s)
 	if err != nil {
 		return nil, err
 	}
 
 	act := &Activity{
-		settings:        s,
-		client:          &client,
-		connectionId:    connectionId,
-		connectionToken: connectionToken,
+		settings: s,
+		query:    query,
+		conn:     conn,
+	}
+
+	switch mode {
+	case ConnectLazy:
+	case ConnectBackground:
+		conn.connectInBackground(ctx.Logger())
+	default:
+		_, _, err = conn.connection()
+		if err != nil {
+			act.disconnect()
+			return nil, err
+		}
 	}
 
 	return act, nil
@@ -95,12 +142,29 @@ func (a *Activity) Eval(ctx activity.Context) (done bool, err error) {
 		return false, err
 	}
 
-	queryObj, err := parseQuery(a.settings.Query, in.Params)
+	request, err := a.query.Bind(in.Params)
 	if err != nil {
 		return false, err
 	}
 
-	queryResponse, err := a.executeQuery(*queryObj)
+	queryResponse, clientSide, err := a.executeQuery(request)
+	if err != nil {
+		return false, err
+	}
+
+	err = request.Complete(queryResponse.Results)
+	if err != nil {
+		return false, err
+	}
+
+	distinct := ""
+	if request.Distinct {
+		distinct = DistinctServer
+		if clientSide {
+			distinct = DistinctClient
+		}
+	}
+	err = ctx.SetOutput("distinct", distinct)
 	if err != nil {
 		return false, err
 	}
@@ -122,10 +186,29 @@ func (a *Activity) Eval(ctx activity.Context) (done bool, err error) {
 	return true, nil
 }
 
-func connect(client http.Client, s *Settings) (string, string, error) {
+// checkConnectionSettings reports the settings missing to connect
+func checkConnectionSettings(s *Settings) error {
 
 	if s.URL == "" {
-		return "", "", fmt.Errorf("'url' is required")
+		return fmt.Errorf("'url' is required")
+	}
+
+	if s.UcsConnectionId != "" {
+		if s.UcsConnectionToken == "" {
+			return fmt.Errorf("'ucsConnectionToken' is required")
+		}
+	} else if s.ConnectorName == "" {
+		return fmt.Errorf("'connectorName' is required")
+	}
+
+	return nil
+}
+
+func connect(client http.Client, s *Settings) (string, string, error) {
+
+	err := checkConnectionSettings(s)
+	if err != nil {
+		return "", "", err
 	}
 
 	if s.UcsConnectionId != "" {
@@ -143,26 +226,17 @@ func connect(client http.Client, s *Settings) (string, string, error) {
 	}
 }
 
+// disconnect releases the shared connection, it is closed when no other
+// activity uses it
 func (a *Activity) disconnect() {
 
-	if a.connectionId != "" {
-		baseUrl := a.settings.URL
-		uri := baseUrl + fmt.Sprintf("/connections/%s", a.connectionId)
-
-		headers := make(map[string]string)
-		headers["Content-Type"] = "application/json"
-		headers["Token"] = a.connectionToken
-
-		getRestResponse(*a.client, MethodDELETE, uri, headers, nil)
-	}
+	a.release.Do(func() {
+		a.conn.release()
+	})
 }
 
 func connectNative(client http.Client, s *Settings) (string, string, error) {
 
-	if s.ConnectorName == "" {
-		return "", "", fmt.Errorf("'connectorName' is required")
-	}
-
 	yukonConn := &YukonConnection{
 		ConnectorName:   s.ConnectorName,
 		ConnectionProps: s.ConnectorProps,
@@ -201,50 +275,205 @@ func connectNative(client http.Client, s *Settings) (string, string, error) {
 	return yukonConn.Id, yukonConn.Token, nil
 }
 
+// connectViaUCS opens a Yukon session for an existing UCS connection, the
+// server validates the UCS connection id and token and returns the id and
+// token of the session used by the queries
 func connectViaUCS(client http.Client, s *Settings) (string, string, error) {
 
-	if s.UcsConnectionToken == "" {
-		return "", "", fmt.Errorf("'ucsConnectionToken' is required")
+	ucsConn := &UcsConnection{
+		Id:    s.UcsConnectionId,
+		Token: s.UcsConnectionToken,
+	}
+
+	baseUrl := s.URL
+	uri := baseUrl + "/ucs/connections"
+
+	headers := make(map[string]string)
+	headers["Content-Type"] = "application/json"
+
+	reqBodyJSON, err := json.Marshal(ucsConn)
+	if err != nil {
+		return "", "", err
 	}
+	reqBody := bytes.NewBuffer([]byte(reqBodyJSON))
 
-	var connectionId = ""
-	var connectionToken = ""
+	resp, err := getRestResponse(client, MethodPOST, uri, headers, reqBody)
+	if err != nil {
+		if resp != nil {
+			_ = resp.Body.Close()
+		}
+		return "", "", fmt.Errorf("UCS connection '%s' failed: %s", s.UcsConnectionId, err.Error())
+	}
+	defer resp.Body.Close()
 
-	return connectionId, connectionToken, nil
+	ucsConn = &UcsConnection{}
+	err = json.NewDecoder(resp.Body).Decode(ucsConn)
+	if err != nil {
+		return "", "", err
+	}
+
+	if ucsConn.IsConnected == false {
+		if ucsConn.Error != "" {
+			return "", "", fmt.Errorf("UCS connection '%s' failed: %s", s.UcsConnectionId, ucsConn.Error)
+		}
+		return "", "", fmt.Errorf("UCS connection '%s' failed", s.UcsConnectionId)
+	}
+
+	if ucsConn.Id == "" {
+		return "", "", fmt.Errorf("UCS connection '%s' failed: no connection id returned", s.UcsConnectionId)
+	}
+
+	return ucsConn.Id, ucsConn.Token, nil
 }
 
-func (a *Activity) executeQuery(queryObject Query) (*YukonQueryResponse, error) {
+// executeQuery runs the query, clientSide reports whether the results were
+// computed by the activity from all the matching rows. The rows are fetched
+// when the query cannot be sent as is or when the server rejects its $apply
+func (a *Activity) executeQuery(request *sqlodata.ODataRequest) (*YukonQueryResponse, bool, error) {
 
-	baseUrl := a.settings.URL
-	uri := baseUrl + fmt.Sprintf("/connections/%s/query/%s?$select=%s", a.connectionId, queryObject.From, url.QueryEscape(queryObject.Select))
+	fallback := request.Fallback()
+
+	if !request.ClientSide() {
+		queryResponse, statusCode, err := a.getQueryResponse(request)
+		if err == nil || fallback == nil || (statusCode != http.StatusBadRequest && statusCode != http.StatusNotImplemented) {
+			return queryResponse, false, err
+		}
+	}
+
+	rows, err := a.fetchAll(fallback)
+	if err != nil {
+		return nil, true, err
+	}
+
+	results, err := request.Evaluate(rows)
+	if err != nil {
+		return nil, true, err
+	}
+
+	return &YukonQueryResponse{EOF: true, Results: results}, true, nil
+}
+
+// defaultMaxRows is the number of rows fetchAll reads at most when maxRows is
+// not set
+const defaultMaxRows = 100000
+
+// fetchAll reads all the pages of a query until the server reports eof. A
+// query selecting all the columns first reads a row to sort on its columns,
+// as the pages are only consistent when the rows are read in a stable order
+func (a *Activity) fetchAll(request *sqlodata.ODataRequest) ([]interface{}, error) {
+
+	if len(request.Select) == 1 && request.Select[0] == "*" {
+		top := 1
+		sample := *request
+		sample.Top = &top
+
+		queryResponse, _, err := a.getQueryResponse(&sample)
+		if err != nil {
+			return nil, err
+		}
+		if len(queryResponse.Results) == 0 {
+			return nil, nil
+		}
+		if row, ok := queryResponse.Results[0].(map[string]interface{}); ok {
+			request.OrderByRow(row)
+		}
+	}
+
+	maxRows := a.settings.MaxRows
+	if maxRows <= 0 {
+		maxRows = defaultMaxRows
+	}
 
-	if queryObject.Top != "" {
-		uri += fmt.Sprintf("&$top=%s", queryObject.Top)
+	var rows []interface{}
+
+	for {
+		if len(rows) > 0 {
+			skip := len(rows)
+			request.Skip = &skip
+		}
+
+		queryResponse, _, err := a.getQueryResponse(request)
+		if err != nil {
+			return nil, err
+		}
+
+		rows = append(rows, queryResponse.Results...)
+
+		if len(rows) > maxRows {
+			return nil, fmt.Errorf("the query reads more than %d rows to compute its results in the activity, narrow its where clause or raise maxRows", maxRows)
+		}
+
+		if queryResponse.EOF || len(queryResponse.Results) == 0 {
+			return rows, nil
+		}
 	}
-	if queryObject.Skip != "" {
-		uri += fmt.Sprintf("&$skip=%s", queryObject.Skip)
+}
+
+// isConnectionLost reports whether a failed query was rejected because the
+// connection is no longer valid, the Yukon server answers 401 once the session
+// of a connection expired and 404 for a connection it does not know. As 404 is
+// also the answer for an unknown entity set, the query is retried once on a new
+// connection and fails if it is rejected again. Other errors, e.g. 403, are
+// errors of the query
+func isConnectionLost(statusCode int) bool {
+	return statusCode == http.StatusUnauthorized || statusCode == http.StatusNotFound
+}
+
+func (a *Activity) getQueryUri(request *sqlodata.ODataRequest, connectionId string) string {
+
+	baseUrl := a.settings.URL
+	return request.URL(baseUrl + fmt.Sprintf("/connections/%s/query", connectionId))
+}
+
+// getQueryResponse executes the query and decodes the response, the status
+// code is returned so callers can react to specific server errors. The query
+// is retried once on a new connection when the connection was lost
+func (a *Activity) getQueryResponse(request *sqlodata.ODataRequest) (*YukonQueryResponse, int, error) {
+
+	connectionId, connectionToken, err := a.conn.connection()
+	if err != nil {
+		return nil, 0, err
 	}
-	if queryObject.Where != "" {
-		uri += fmt.Sprintf("&$filter=%s", url.QueryEscape(queryObject.Where))
+
+	queryResponse, statusCode, lost, err := a.sendQuery(request, connectionId, connectionToken)
+	if !lost {
+		return queryResponse, statusCode, err
 	}
-	if queryObject.Orderby != "" {
-		uri += fmt.Sprintf("&$orderby=%s", url.QueryEscape(queryObject.Orderby))
+
+	connectionId, connectionToken, err = a.conn.reconnect(connectionId)
+	if err != nil {
+		return nil, statusCode, fmt.Errorf("reconnection failed: %s", err.Error())
 	}
 
+	queryResponse, statusCode, _, err = a.sendQuery(request, connectionId, connectionToken)
+	return queryResponse, statusCode, err
+}
+
+// sendQuery executes the query on a connection, lost reports whether the
+// query failed because the connection is no longer valid
+func (a *Activity) sendQuery(request *sqlodata.ODataRequest, connectionId string, connectionToken string) (*YukonQueryResponse, int, bool, error) {
+
+	uri := a.getQueryUri(request, connectionId)
+
 	headers := make(map[string]string)
 	headers["Content-Type"] = "application/json"
-	headers["Token"] = a.connectionToken
+	headers["Token"] = connectionToken
 
-	resp, err := getRestResponse(*a.client, MethodGET, uri, headers, nil)
+	resp, err := getRestResponse(*a.conn.client, MethodGET, uri, headers, nil)
 	if err != nil {
-		return nil, err
+		if resp == nil {
+			return nil, 0, false, err
+		}
+		_ = resp.Body.Close()
+		return nil, resp.StatusCode, isConnectionLost(resp.StatusCode), err
 	}
+	defer resp.Body.Close()
 
 	queryResponse := YukonQueryResponse{}
 	err = json.NewDecoder(resp.Body).Decode(&queryResponse)
 	if err != nil {
-		return nil, err
+		return nil, resp.StatusCode, false, err
 	}
 
-	return &queryResponse, nil
+	return &queryResponse, resp.StatusCode, false, nil
 }
@@ -1,8 +1,26 @@
 package yukonquery
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/ecoletibco/yukonquery/sqlodata"
 	"github.com/project-flogo/core/activity"
 	"github.com/project-flogo/core/data/mapper"
 	"github.com/project-flogo/core/data/resolve"
@@ -589,148 +607,726 @@ func TestEvalSimpleSelectWithOrderByDesc(t *testing.T) {
 	assert.True(t, firstIndex == 1) // benchmark does not support orderby
 }
 
-func TestParseQuery(t *testing.T) {
+func TestNewInvalidQuery(t *testing.T) {
 
-	// basic select * query
-	_, err := parseQuery("select * from entity2", nil)
-	assert.Nil(t, err)
+	settings := &Settings{
+		URL:           "http://localhost:1",
+		ConnectorName: TestConnectorName,
+		Query:         "select * from",
+	}
 
-	// messy select * query
-	_, err = parseQuery(" select  *  from  entity2 ", nil)
-	assert.Nil(t, err)
+	iCtx := test.NewActivityInitContext(settings, nil)
+	_, err := New(iCtx)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "invalid query")
+}
+
+func TestExecuteAggregateQueryFallback(t *testing.T) {
 
-	// select column query
-	_, err = parseQuery("select index from entity2", nil)
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.RawQuery)
+
+		if r.URL.Query().Get("$apply") != "" {
+			w.WriteHeader(http.StatusNotImplemented)
+			return
+		}
+
+		var response YukonQueryResponse
+		if r.URL.Query().Get("$skip") == "" {
+			response.Results = []interface{}{
+				map[string]interface{}{"Country": "CA", "Amount": 10},
+				map[string]interface{}{"Country": "US", "Amount": 5},
+			}
+		} else {
+			response.EOF = true
+			response.Results = []interface{}{
+				map[string]interface{}{"Country": "CA", "Amount": 30},
+			}
+		}
+		_ = json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+
+	act := &Activity{
+		settings: &Settings{URL: server.URL},
+		conn: &connectionManager{
+			settings: &Settings{URL: server.URL},
+			client:   server.Client(),
+			id:       "id",
+		},
+	}
+
+	queryObj, err := sqlodata.Translate("select country, sum(amount) as total from account where amount > 0 group by country order by total desc", nil)
 	assert.Nil(t, err)
 
-	// select columns query
-	_, err = parseQuery("select index, prop1 from entity2", nil)
+	queryResponse, clientSide, err := act.executeQuery(queryObj)
+	assert.Nil(t, err)
+	assert.True(t, queryResponse.EOF)
+	assert.True(t, clientSide)
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"country": "CA", "total": 40.0},
+		map[string]interface{}{"country": "US", "total": 5.0},
+	}, queryResponse.Results)
+
+	assert.Equal(t, 3, len(requests))
+	assert.Contains(t, requests[1], "$filter=amount+gt+0")
+	assert.Contains(t, requests[1], "$orderby=country%2C+amount")
+	assert.Contains(t, requests[2], "$skip=2")
+
+	// count(*) reads a single column and the rows read are limited
+	requests = nil
+	act.settings.MaxRows = 2
+	queryObj, err = sqlodata.Translate("select count(*) as total from account where amount > 0", nil)
 	assert.Nil(t, err)
 
-	// select * query with top
-	_, err = parseQuery("select top 100 * from entity2", nil)
+	_, _, err = act.executeQuery(queryObj)
+	assert.EqualError(t, err, "the query reads more than 2 rows to compute its results in the activity, narrow its where clause or raise maxRows")
+	assert.Equal(t, 4, len(requests))
+	assert.Contains(t, requests[1], "$select=%2A&$top=1")
+	assert.Contains(t, requests[2], "$select=Amount&$filter=amount+gt+0&$orderby=Amount")
+
+	requests = nil
+	act.settings.MaxRows = 0
+	queryResponse, _, err = act.executeQuery(queryObj)
 	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{map[string]interface{}{"total": 3.0}}, queryResponse.Results)
+}
+
+func TestExecuteDistinctQuery(t *testing.T) {
+
+	var requests []string
+	applySupported := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.RawQuery)
+
+		var response YukonQueryResponse
+		if r.URL.Query().Get("$apply") != "" {
+			if !applySupported {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			response.EOF = true
+			response.Results = []interface{}{
+				map[string]interface{}{"Country": "CA"},
+				map[string]interface{}{"Country": "US"},
+			}
+		} else {
+			response.EOF = true
+			response.Results = []interface{}{
+				map[string]interface{}{"Country": "US", "City": "Austin"},
+				map[string]interface{}{"Country": "CA", "City": "Ottawa"},
+				map[string]interface{}{"Country": "US", "City": "Austin"},
+				map[string]interface{}{"Country": "US", "City": "Boston"},
+			}
+		}
+		_ = json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+
+	act := &Activity{
+		settings: &Settings{URL: server.URL},
+		conn: &connectionManager{
+			settings: &Settings{URL: server.URL},
+			client:   server.Client(),
+			id:       "id",
+		},
+	}
 
-	// select * query with skip first
-	_, err = parseQuery("select skip 100 * from entity2", nil)
+	queryObj, err := sqlodata.Translate("select distinct Country from Account", nil)
 	assert.Nil(t, err)
 
-	// select * query with skip last
-	_, err = parseQuery("select * from entity2 skip 100", nil)
+	queryResponse, clientSide, err := act.executeQuery(queryObj)
 	assert.Nil(t, err)
+	assert.False(t, clientSide)
+	assert.Equal(t, 2, len(queryResponse.Results))
+	assert.Contains(t, requests[0], "$apply=groupby%28%28Country%29%29")
 
-	// select * query with top and skip
-	_, err = parseQuery("select top 100 skip 100 * from entity2", nil)
+	applySupported = false
+	queryObj, err = sqlodata.Translate("select distinct Country from Account order by Country desc", nil)
 	assert.Nil(t, err)
 
-	// select * query with where
-	_, err = parseQuery("select * from entity2 where index < 5", nil)
+	queryResponse, clientSide, err = act.executeQuery(queryObj)
+	assert.Nil(t, err)
+	assert.True(t, clientSide)
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"Country": "US"},
+		map[string]interface{}{"Country": "CA"},
+	}, queryResponse.Results)
+
+	requests = nil
+	queryObj, err = sqlodata.Translate("select distinct * from Account limit 2 offset 1", nil)
 	assert.Nil(t, err)
 
-	// select * query with where with and and or
-	_, err = parseQuery("select * from entity2 where index < 5 or prop1 == 'xxxxx'", nil)
+	queryResponse, clientSide, err = act.executeQuery(queryObj)
 	assert.Nil(t, err)
+	assert.True(t, clientSide)
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"Country": "CA", "City": "Ottawa"},
+		map[string]interface{}{"Country": "US", "City": "Boston"},
+	}, queryResponse.Results)
+	// a row is read to sort the rows by its columns
+	assert.Equal(t, 2, len(requests))
+	assert.Contains(t, requests[0], "$top=1")
+	assert.Contains(t, requests[1], "$orderby=City%2C+Country")
+	assert.NotContains(t, requests[1], "$top")
+	assert.NotContains(t, requests[1], "$skip")
+}
+
+func TestExecuteQueryExpand(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Contacts($select=Email;$filter=$it/Id eq AccountId)", r.URL.Query().Get("$expand"))
+
+		response := YukonQueryResponse{EOF: true, Results: []interface{}{
+			map[string]interface{}{"Name": "Acme", "Contacts": []interface{}{
+				map[string]interface{}{"Email": "a@acme.com"},
+				map[string]interface{}{"Email": "b@acme.com"},
+			}},
+		}}
+		_ = json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+
+	act := &Activity{
+		settings: &Settings{URL: server.URL},
+		conn: &connectionManager{
+			settings: &Settings{URL: server.URL},
+			client:   server.Client(),
+			id:       "id",
+		},
+	}
 
-	// select * query with orderby
-	_, err = parseQuery("select * from entity2 orderby index", nil)
+	queryObj, err := sqlodata.Translate("select a.Name as Account, c.Email from Account a left join Contacts c on a.Id = c.AccountId", nil)
 	assert.Nil(t, err)
 
-	// select * query with orderby asc
-	_, err = parseQuery("select * from entity2 orderby index asc", nil)
+	queryResponse, _, err := act.executeQuery(queryObj)
 	assert.Nil(t, err)
+	assert.Nil(t, queryObj.Complete(queryResponse.Results))
+
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"Account": "Acme", "Contacts": []interface{}{
+			map[string]interface{}{"Email": "a@acme.com"},
+			map[string]interface{}{"Email": "b@acme.com"},
+		}},
+	}, queryResponse.Results)
+}
 
-	// select * query with orderby desc
-	_, err = parseQuery("select * from entity2 orderby index desc", nil)
+func TestConnectViaUCS(t *testing.T) {
+
+	var deleted []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/ucs/connections":
+			var conn UcsConnection
+			_ = json.NewDecoder(r.Body).Decode(&conn)
+			switch conn.Token {
+			case "good":
+				conn = UcsConnection{Id: "session-" + conn.Id, Token: "session-token", IsConnected: true}
+			case "expired":
+				conn = UcsConnection{Id: conn.Id, Error: "token expired"}
+			default:
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(conn)
+		case r.Method == http.MethodGet && r.URL.Path == "/connections/session-ucs1/query/Account":
+			assert.Equal(t, "session-token", r.Header.Get("Token"))
+			_ = json.NewEncoder(w).Encode(YukonQueryResponse{EOF: true, Results: []interface{}{map[string]interface{}{"Name": "Acme"}}})
+		case r.Method == http.MethodDelete:
+			deleted = append(deleted, r.URL.Path)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	settings := &Settings{
+		URL:                server.URL,
+		UcsConnectionId:    "ucs1",
+		UcsConnectionToken: "good",
+		Query:              "select Name from Account",
+	}
+
+	act, err := New(test.NewActivityInitContext(settings, nil))
 	assert.Nil(t, err)
 
-	// messy big fat pig query
-	_, err = parseQuery(" Select top  100  skip  100  index , prop1 from  entity2 where index < 5 or prop1 == 'xxxxx'  orderby index   desc  ", nil)
+	tc := test.NewActivityContext(act.Metadata())
+	done, err := act.Eval(tc)
 	assert.Nil(t, err)
+	assert.True(t, done)
+	assert.Equal(t, []interface{}{map[string]interface{}{"Name": "Acme"}}, tc.GetOutput("results"))
 
-	// blank query
-	_, err = parseQuery("", nil)
-	assert.NotNil(t, err)
+	assert.Nil(t, act.(*Activity).Cleanup())
+	assert.Equal(t, []string{"/connections/session-ucs1"}, deleted)
 
-	// only select
-	_, err = parseQuery("select", nil)
+	// isConnected false with the reason
+	settings.UcsConnectionToken = "expired"
+	_, err = New(test.NewActivityInitContext(settings, nil))
 	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "token expired")
 
-	// no columns
-	_, err = parseQuery("select from entity2", nil)
+	// rejected
+	settings.UcsConnectionToken = "bad"
+	_, err = New(test.NewActivityInitContext(settings, nil))
 	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "401")
 
-	// no from
-	_, err = parseQuery("select *", nil)
+	// missing token
+	settings.UcsConnectionToken = ""
+	_, err = New(test.NewActivityInitContext(settings, nil))
 	assert.NotNil(t, err)
+}
+
+func TestReconnect(t *testing.T) {
+
+	var mutex sync.Mutex
+	connections := 0
+	valid := map[string]bool{}
+	unknown := map[string]bool{}
+	var deleted []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/connections":
+			connections++
+			id := fmt.Sprintf("c%d", connections)
+			valid[id] = true
+			_ = json.NewEncoder(w).Encode(YukonConnection{Id: id, Token: "token-" + id, IsConnected: true})
+		case r.Method == http.MethodDelete:
+			deleted = append(deleted, r.URL.Path)
+		case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/query/Secret"):
+			w.WriteHeader(http.StatusForbidden)
+		case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/query/Account"):
+			id := strings.Split(r.URL.Path, "/")[2]
+			if unknown[id] {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			if !valid[id] || r.Header.Get("Token") != "token-"+id {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(YukonQueryResponse{EOF: true, Results: []interface{}{map[string]interface{}{"Name": id}}})
+		case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/query/Missing"):
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte("entity set Missing not found"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	settings := &Settings{
+		URL:           server.URL,
+		ConnectorName: "Benchmark",
+		Query:         "select Name from Account",
+	}
+
+	act, err := New(test.NewActivityInitContext(settings, nil))
+	assert.Nil(t, err)
+
+	// the session expires, concurrent executions reconnect once
+	mutex.Lock()
+	valid["c1"] = false
+	mutex.Unlock()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tc := test.NewActivityContext(act.Metadata())
+			done, err := act.Eval(tc)
+			assert.Nil(t, err)
+			assert.True(t, done)
+			assert.Equal(t, []interface{}{map[string]interface{}{"Name": "c2"}}, tc.GetOutput("results"))
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 2, connections)
+
+	// the expired connection is released
+	mutex.Lock()
+	assert.Equal(t, []string{"/connections/c1"}, deleted)
+	mutex.Unlock()
+
+	// a connection the server does not know is replaced
+	mutex.Lock()
+	unknown["c2"] = true
+	mutex.Unlock()
 
-	// no table
-	_, err = parseQuery("select * from", nil)
+	tc := test.NewActivityContext(act.Metadata())
+	_, err = act.Eval(tc)
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{map[string]interface{}{"Name": "c3"}}, tc.GetOutput("results"))
+	mutex.Lock()
+	assert.Equal(t, 3, connections)
+	assert.Equal(t, []string{"/connections/c1", "/connections/c2"}, deleted)
+	mutex.Unlock()
+
+	// an unknown entity set fails once retried on a new connection
+	request, err := sqlodata.Translate("select Name from Missing", nil)
+	assert.Nil(t, err)
+	_, _, err = act.(*Activity).executeQuery(request)
 	assert.NotNil(t, err)
+	assert.Equal(t, 4, connections)
 
-	// no where values
-	_, err = parseQuery("select * from entity2 where", nil)
+	// other errors of the query do not reconnect
+	request, err = sqlodata.Translate("select Name from Secret", nil)
+	assert.Nil(t, err)
+	_, _, err = act.(*Activity).executeQuery(request)
 	assert.NotNil(t, err)
+	assert.Equal(t, 4, connections)
+
+	// the query fails when the reconnection fails
+	mutex.Lock()
+	valid["c4"] = false
+	mutex.Unlock()
+	act.(*Activity).settings.ConnectorName = ""
 
-	// no orderby values
-	_, err = parseQuery("select * from entity2 orderby", nil)
+	tc = test.NewActivityContext(act.Metadata())
+	_, err = act.Eval(tc)
 	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "reconnection failed")
 }
 
-func TestBuildWherePart(t *testing.T) {
+func TestConnectMode(t *testing.T) {
+
+	var mutex sync.Mutex
+	available := false
+	attempts := 0
+	var deleted []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/connections":
+			attempts++
+			if !available {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(YukonConnection{Id: "c1", Token: "token", IsConnected: true})
+		case r.Method == http.MethodGet && r.URL.Path == "/connections/c1/query/Account":
+			_ = json.NewEncoder(w).Encode(YukonQueryResponse{EOF: true, Results: []interface{}{map[string]interface{}{"Name": "Acme"}}})
+		case r.Method == http.MethodDelete:
+			deleted = append(deleted, r.URL.Path)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	setAvailable := func(value bool) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		available = value
+		attempts = 0
+		deleted = nil
+	}
+	getAttempts := func() int {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return attempts
+	}
+
+	settings := &Settings{
+		URL:           server.URL,
+		ConnectorName: "Benchmark",
+		Query:         "select Name from Account",
+	}
 
-	// valid
-	_, err := buildWherePart("a", "=", "b", "")
-	assert.Nil(t, err)
+	// eager fails when the server is not available
+	_, err := New(test.NewActivityInitContext(settings, nil))
+	assert.NotNil(t, err)
 
-	_, err = buildWherePart("a", "==", "b", "")
+	// lazy connects on the first execution that finds the server available
+	settings.ConnectMode = "Lazy"
+	act, err := New(test.NewActivityInitContext(settings, nil))
 	assert.Nil(t, err)
 
-	_, err = buildWherePart("a", "!=", "b", "")
-	assert.Nil(t, err)
+	_, err = act.Eval(test.NewActivityContext(act.Metadata()))
+	assert.NotNil(t, err)
 
-	_, err = buildWherePart("a", "<>", "b", "")
+	setAvailable(true)
+	tc := test.NewActivityContext(act.Metadata())
+	done, err := act.Eval(tc)
 	assert.Nil(t, err)
+	assert.True(t, done)
+	assert.Equal(t, []interface{}{map[string]interface{}{"Name": "Acme"}}, tc.GetOutput("results"))
 
-	_, err = buildWherePart("a", ">", "b", "")
-	assert.Nil(t, err)
+	assert.Nil(t, act.(*Activity).Cleanup())
+	assert.Equal(t, []string{"/connections/c1"}, deleted)
 
-	_, err = buildWherePart("a", "<", "b", "")
-	assert.Nil(t, err)
+	// background retries until the server is available
+	connectRetryDelay, connectRetryMaxDelay = time.Millisecond, 5*time.Millisecond
+	defer func() {
+		connectRetryDelay, connectRetryMaxDelay = time.Second, time.Minute
+	}()
 
-	_, err = buildWherePart("a", ">=", "b", "")
+	setAvailable(false)
+	settings.ConnectMode = ConnectBackground
+	act, err = New(test.NewActivityInitContext(settings, nil))
 	assert.Nil(t, err)
 
-	_, err = buildWherePart("a", "<=", "b", "")
+	assert.True(t, waitFor(func() bool { return getAttempts() >= 3 }))
+	mutex.Lock()
+	available = true
+	mutex.Unlock()
+	assert.True(t, waitFor(func() bool {
+		act.(*Activity).conn.mutex.RLock()
+		defer act.(*Activity).conn.mutex.RUnlock()
+		return act.(*Activity).conn.id == "c1"
+	}))
+
+	assert.Nil(t, act.(*Activity).Cleanup())
+	assert.Equal(t, []string{"/connections/c1"}, deleted)
+
+	// the background connection stops when the activity is cleaned up
+	setAvailable(false)
+	act, err = New(test.NewActivityInitContext(settings, nil))
 	assert.Nil(t, err)
+	assert.Nil(t, act.(*Activity).Cleanup())
 
-	_, err = buildWherePart("a", "!>", "b", "")
-	assert.Nil(t, err)
+	_, err = act.Eval(test.NewActivityContext(act.Metadata()))
+	assert.NotNil(t, err)
+
+	// the settings are checked in all the modes
+	settings.ConnectorName = ""
+	settings.ConnectMode = ConnectLazy
+	_, err = New(test.NewActivityInitContext(settings, nil))
+	assert.NotNil(t, err)
+
+	settings.ConnectorName = "Benchmark"
+	settings.ConnectMode = "later"
+	_, err = New(test.NewActivityInitContext(settings, nil))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "connectMode")
+}
 
-	_, err = buildWherePart("a", "!<", "b", "")
+func TestConnectWithoutBlocking(t *testing.T) {
+
+	var mutex sync.Mutex
+	var deleted []string
+	connecting, unblock := make(chan struct{}, 1), make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/connections":
+			// the server accepts the connections but does not answer
+			connecting <- struct{}{}
+			<-unblock
+			_ = json.NewEncoder(w).Encode(YukonConnection{Id: "c1", Token: "token", IsConnected: true})
+		case r.Method == http.MethodDelete:
+			mutex.Lock()
+			deleted = append(deleted, r.URL.Path)
+			mutex.Unlock()
+		}
+	}))
+	defer server.Close()
+
+	newSettings := func(db string, mode string) *Settings {
+		return &Settings{
+			URL:            server.URL,
+			ConnectorName:  "Benchmark",
+			ConnectorProps: map[string]string{"db": db},
+			Query:          "select Name from Account",
+			ConnectMode:    mode,
+		}
+	}
+
+	act1, err := New(test.NewActivityInitContext(newSettings("sales", ConnectBackground), nil))
 	assert.Nil(t, err)
+	<-connecting
 
-	_, err = buildWherePart("a", "=", "b", "and")
+	// the activities are created and cleaned up while the connection is opened
+	start := time.Now()
+	act2, err := New(test.NewActivityInitContext(newSettings("sales", ConnectBackground), nil))
+	assert.Nil(t, err)
+	act3, err := New(test.NewActivityInitContext(newSettings("support", ConnectLazy), nil))
 	assert.Nil(t, err)
+	assert.Nil(t, act3.(*Activity).Cleanup())
+	assert.Nil(t, act2.(*Activity).Cleanup())
+	assert.Nil(t, act1.(*Activity).Cleanup())
+	assert.True(t, time.Since(start) < time.Second)
+
+	// the connection opened once all the activities released it is closed
+	close(unblock)
+	assert.True(t, waitFor(func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return len(deleted) == 1 && deleted[0] == "/connections/c1"
+	}))
+}
 
-	_, err = buildWherePart("a", "=", "b", "or")
+func TestSharedConnection(t *testing.T) {
+
+	var mutex sync.Mutex
+	var connected, deleted []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/connections":
+			var conn YukonConnection
+			_ = json.NewDecoder(r.Body).Decode(&conn)
+			id := fmt.Sprintf("c%d", len(connected)+1)
+			connected = append(connected, conn.ConnectionProps["db"])
+			_ = json.NewEncoder(w).Encode(YukonConnection{Id: id, Token: "token", IsConnected: true})
+		case r.Method == http.MethodGet:
+			id := strings.Split(r.URL.Path, "/")[2]
+			_ = json.NewEncoder(w).Encode(YukonQueryResponse{EOF: true, Results: []interface{}{map[string]interface{}{"Connection": id}}})
+		case r.Method == http.MethodDelete:
+			deleted = append(deleted, r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	newActivity := func(query string, db string) activity.Activity {
+		settings := &Settings{
+			URL:            server.URL,
+			ConnectorName:  "Benchmark",
+			ConnectorProps: map[string]string{"db": db, "user": "admin"},
+			Query:          query,
+		}
+		act, err := New(test.NewActivityInitContext(settings, nil))
+		assert.Nil(t, err)
+		return act
+	}
+	eval := func(act activity.Activity) interface{} {
+		tc := test.NewActivityContext(act.Metadata())
+		_, err := act.Eval(tc)
+		assert.Nil(t, err)
+		return tc.GetOutput("results")
+	}
+
+	// the activities with the same connection settings share the connection
+	act1 := newActivity("select Name from Account", "sales")
+	act2 := newActivity("select Email from Contact", "sales")
+	act3 := newActivity("select Name from Account", "support")
+	assert.Equal(t, []string{"sales", "support"}, connected)
+	assert.True(t, act1.(*Activity).conn == act2.(*Activity).conn)
+
+	assert.Equal(t, []interface{}{map[string]interface{}{"Connection": "c1"}}, eval(act1))
+	assert.Equal(t, []interface{}{map[string]interface{}{"Connection": "c1"}}, eval(act2))
+	assert.Equal(t, []interface{}{map[string]interface{}{"Connection": "c2"}}, eval(act3))
+
+	// the last activity using the connection closes it
+	assert.Nil(t, act1.(*Activity).Cleanup())
+	assert.Nil(t, act1.(*Activity).Cleanup())
+	assert.Empty(t, deleted)
+	assert.Equal(t, []interface{}{map[string]interface{}{"Connection": "c1"}}, eval(act2))
+
+	assert.Nil(t, act2.(*Activity).Cleanup())
+	assert.Equal(t, []string{"/connections/c1"}, deleted)
+
+	// a new activity opens a new connection
+	act4 := newActivity("select Name from Account", "sales")
+	assert.Equal(t, []interface{}{map[string]interface{}{"Connection": "c3"}}, eval(act4))
+
+	assert.Nil(t, act3.(*Activity).Cleanup())
+	assert.Nil(t, act4.(*Activity).Cleanup())
+	assert.Equal(t, []string{"/connections/c1", "/connections/c2", "/connections/c3"}, deleted)
+}
+
+// waitFor polls condition until it is true or a second has elapsed
+func waitFor(condition func() bool) bool {
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if condition() {
+			return true
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return condition()
+}
+
+func TestTLSSettings(t *testing.T) {
+
+	// the client certificate of the mTLS cases
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "yukonquery"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+	clientCert := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	clientKey := string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}))
+	clientCA, err := x509.ParseCertificate(der)
 	assert.Nil(t, err)
 
-	// invalid
-	_, err = buildWherePart("", "", "", "")
-	assert.NotNil(t, err)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/mtls/") && len(r.TLS.PeerCertificates) == 0:
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/connections"):
+			_ = json.NewEncoder(w).Encode(YukonConnection{Id: "c1", Token: "token", IsConnected: true})
+		}
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.VerifyClientCertIfGiven, ClientCAs: x509.NewCertPool()}
+	server.TLS.ClientCAs.AddCert(clientCA)
+	server.StartTLS()
+	defer server.Close()
+
+	// the certificate of httptest servers is valid for example.com and 127.0.0.1
+	caCert := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	assert.Nil(t, ioutil.WriteFile(caFile, []byte(caCert), 0600))
+
+	newActivity := func(settings *Settings) error {
+		if settings.URL == "" {
+			settings.URL = server.URL
+		}
+		settings.ConnectorName = "Benchmark"
+		settings.Query = "select Name from Account"
+		act, err := New(test.NewActivityInitContext(settings, nil))
+		if err == nil {
+			_ = act.(*Activity).Cleanup()
+		}
+		return err
+	}
 
-	_, err = buildWherePart("a", "", "", "")
-	assert.NotNil(t, err)
+	// the certificate is not trusted by default
+	assert.NotNil(t, newActivity(&Settings{}))
+
+	assert.Nil(t, newActivity(&Settings{TLSCaCert: caCert}))
+	assert.Nil(t, newActivity(&Settings{TLSCaCert: caFile}))
+	assert.Nil(t, newActivity(&Settings{TLSSkipVerify: true}))
+
+	assert.Nil(t, newActivity(&Settings{TLSCaCert: caCert, TLSServerName: "example.com"}))
+	assert.NotNil(t, newActivity(&Settings{TLSCaCert: caCert, TLSServerName: "yukon.example.org"}))
+
+	// client certificates, required under /mtls
+	mtls := server.URL + "/mtls"
+	assert.NotNil(t, newActivity(&Settings{URL: mtls, TLSCaCert: caCert}))
+	assert.Nil(t, newActivity(&Settings{URL: mtls, TLSCaCert: caCert, TLSClientCert: clientCert, TLSClientKey: clientKey}))
 
-	_, err = buildWherePart("a", "=", "", "")
+	// invalid settings
+	err = newActivity(&Settings{TLSCaCert: filepath.Join(t.TempDir(), "missing.pem")})
 	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "tlsCaCert")
 
-	_, err = buildWherePart("a", "", "b", "")
+	err = newActivity(&Settings{TLSCaCert: "-----BEGIN CERTIFICATE-----\n-----END CERTIFICATE-----\n"})
 	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "tlsCaCert")
 
-	_, err = buildWherePart("a", "??", "b", "")
+	err = newActivity(&Settings{TLSClientCert: clientCert})
 	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "tlsClientKey")
 
-	_, err = buildWherePart("a", "=", "b", "??")
+	err = newActivity(&Settings{TLSClientCert: clientCert, TLSClientKey: clientCert})
 	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "invalid client certificate")
 }
@@ -0,0 +1,276 @@
+package yukonquery
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/project-flogo/core/support/log"
+)
+
+// connectRetryDelay is the delay before the first retry of a background
+// connection, it doubles after each failure up to connectRetryMaxDelay
+var (
+	connectRetryDelay    = time.Second
+	connectRetryMaxDelay = time.Minute
+)
+
+var errClosed = errors.New("the connection is closed")
+
+// managers holds the shared connections by connectionKey
+var (
+	managersMutex sync.Mutex
+	managers      = make(map[string]*connectionManager)
+)
+
+// connectionManager shares a Yukon connection and its http client between the
+// activities connecting to the same server with the same connector and
+// properties, or through the same UCS connection. Each activity acquires it in
+// New and releases it in Cleanup, the last release closes the connection.
+// refs and closed are guarded by managersMutex, the connection by mutex which
+// is never held while calling the server
+type connectionManager struct {
+	key        string
+	settings   *Settings
+	client     *http.Client
+	refs       int
+	closed     bool
+	mutex      sync.RWMutex
+	id         string
+	token      string
+	connecting chan struct{}
+	background bool
+	stop       chan struct{}
+}
+
+// connectionKey identifies the connection opened with the settings
+func connectionKey(s *Settings) string {
+
+	tlsKey := []string{s.TLSCaCert, s.TLSClientCert, s.TLSClientKey, s.TLSServerName, fmt.Sprint(s.TLSSkipVerify)}
+
+	if s.UcsConnectionId != "" {
+		return strings.Join(append([]string{s.URL, "ucs", s.UcsConnectionId, s.UcsConnectionToken}, tlsKey...), "\x00")
+	}
+
+	// the keys of maps are sorted by json
+	props, _ := json.Marshal(s.ConnectorProps)
+	return strings.Join(append([]string{s.URL, "native", s.ConnectorName, string(props)}, tlsKey...), "\x00")
+}
+
+// acquireConnectionManager returns the manager of the connection opened with
+// the settings, created when no activity uses it, with a reference added
+func acquireConnectionManager(s *Settings) (*connectionManager, error) {
+
+	managersMutex.Lock()
+	defer managersMutex.Unlock()
+
+	key := connectionKey(s)
+
+	m, ok := managers[key]
+	if !ok {
+		tlsConfig, err := getTLSConfig(s)
+		if err != nil {
+			return nil, err
+		}
+		client, err := getHttpClient(20, tlsConfig)
+		if err != nil {
+			return nil, err
+		}
+		m = &connectionManager{
+			key:      key,
+			settings: s,
+			client:   &client,
+			stop:     make(chan struct{}),
+		}
+		managers[key] = m
+	}
+
+	m.acquire()
+
+	return m, nil
+}
+
+// acquire adds a reference to the connection, the caller holds managersMutex
+func (m *connectionManager) acquire() {
+	m.refs++
+}
+
+// release removes a reference to the connection and closes it with the last
+// one
+func (m *connectionManager) release() {
+
+	managersMutex.Lock()
+	m.refs--
+	if m.refs > 0 || m.closed {
+		managersMutex.Unlock()
+		return
+	}
+	m.closed = true
+	if managers[m.key] == m {
+		delete(managers, m.key)
+	}
+	managersMutex.Unlock()
+
+	// a connection opened after stop is closed is deleted by reconnect
+	close(m.stop)
+
+	m.mutex.Lock()
+	connectionId, connectionToken := m.id, m.token
+	m.id, m.token = "", ""
+	m.mutex.Unlock()
+
+	m.deleteConnection(connectionId, connectionToken)
+}
+
+// stopped reports whether the connection was released by all the activities
+func (m *connectionManager) stopped() bool {
+
+	select {
+	case <-m.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// deleteConnection closes a connection on the server, failures are ignored as
+// the server also expires the connections that are not used
+func (m *connectionManager) deleteConnection(connectionId string, connectionToken string) {
+
+	if connectionId == "" {
+		return
+	}
+
+	baseUrl := m.settings.URL
+	uri := baseUrl + fmt.Sprintf("/connections/%s", connectionId)
+
+	headers := make(map[string]string)
+	headers["Content-Type"] = "application/json"
+	headers["Token"] = connectionToken
+
+	resp, _ := getRestResponse(*m.client, MethodDELETE, uri, headers, nil)
+	if resp != nil {
+		_ = resp.Body.Close()
+	}
+}
+
+// connection returns the id and token of the current Yukon connection, it
+// connects when the connection is deferred and not opened yet
+func (m *connectionManager) connection() (string, string, error) {
+
+	m.mutex.RLock()
+	connectionId, connectionToken := m.id, m.token
+	m.mutex.RUnlock()
+
+	if connectionId != "" {
+		return connectionId, connectionToken, nil
+	}
+
+	return m.reconnect("")
+}
+
+// reconnect replaces the connection staleId the server no longer accepts, or
+// opens the connection when staleId is empty, and returns the new connection.
+// The stale connection is deleted on the server. A single execution calls the
+// server, the others wait for its connection
+func (m *connectionManager) reconnect(staleId string) (string, string, error) {
+
+	for {
+		m.mutex.Lock()
+
+		if m.stopped() {
+			m.mutex.Unlock()
+			return "", "", errClosed
+		}
+
+		if m.id != "" && m.id != staleId {
+			connectionId, connectionToken := m.id, m.token
+			m.mutex.Unlock()
+			return connectionId, connectionToken, nil
+		}
+
+		if m.connecting != nil {
+			connecting := m.connecting
+			m.mutex.Unlock()
+			select {
+			case <-connecting:
+			case <-m.stop:
+			}
+			continue
+		}
+
+		connecting := make(chan struct{})
+		m.connecting = connecting
+		oldId, oldToken := m.id, m.token
+		m.id, m.token = "", ""
+		m.mutex.Unlock()
+
+		// the stale connection is released so the server does not keep it
+		m.deleteConnection(oldId, oldToken)
+
+		connectionId, connectionToken, err := connect(*m.client, m.settings)
+
+		m.mutex.Lock()
+		m.connecting = nil
+		close(connecting)
+		stopped := m.stopped()
+		if err == nil && !stopped {
+			m.id, m.token = connectionId, connectionToken
+		}
+		m.mutex.Unlock()
+
+		if err != nil {
+			return "", "", err
+		}
+		if stopped {
+			m.deleteConnection(connectionId, connectionToken)
+			return "", "", errClosed
+		}
+
+		return connectionId, connectionToken, nil
+	}
+}
+
+// connectInBackground opens the connection in the background unless it is
+// open or already being opened, the attempts are retried with an exponential
+// backoff until one succeeds, an execution opens the connection or the
+// connection is released
+func (m *connectionManager) connectInBackground(logger log.Logger) {
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.background || m.id != "" {
+		return
+	}
+	m.background = true
+
+	go m.retryConnection(logger, connectRetryDelay, connectRetryMaxDelay)
+}
+
+func (m *connectionManager) retryConnection(logger log.Logger, delay time.Duration, maxDelay time.Duration) {
+
+	for {
+		_, _, err := m.connection()
+		if err == nil || err == errClosed {
+			return
+		}
+
+		logger.Warnf("Connection to the Yukon server '%s' failed, retrying in %s: %s", m.settings.URL, delay, err.Error())
+
+		select {
+		case <-m.stop:
+			return
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		if delay > maxDelay {
+			delay = maxDelay
+		}
+	}
+}
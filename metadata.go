@@ -9,6 +9,13 @@ type Settings struct {
 	ConnectorName      string            `md:"connectorName"`
 	ConnectorProps     map[string]string `md:"connectorProps"`
 	Query              string            `md:"query,required"`
+	ConnectMode        string            `md:"connectMode"`
+	MaxRows            int               `md:"maxRows"`
+	TLSCaCert          string            `md:"tlsCaCert"`
+	TLSClientCert      string            `md:"tlsClientCert"`
+	TLSClientKey       string            `md:"tlsClientKey"`
+	TLSServerName      string            `md:"tlsServerName"`
+	TLSSkipVerify      bool              `md:"tlsSkipVerify"`
 }
 
 type Input struct {
@@ -16,8 +23,9 @@ type Input struct {
 }
 
 type Output struct {
-	EOF     bool                     `md:"eof"`
-	Results []map[string]interface{} `md:"results"`
+	EOF      bool                     `md:"eof"`
+	Results  []map[string]interface{} `md:"results"`
+	Distinct string                   `md:"distinct"`
 }
 
 // FromMap converts the values from a map into the struct Input
@@ -2,10 +2,16 @@ package yukonquery
 
 import (
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -16,21 +22,100 @@ const (
 	MethodDELETE = "DELETE"
 )
 
-func getHttpClient(timeout int) (http.Client, error) {
+func getHttpClient(timeout int, tlsConfig *tls.Config) (http.Client, error) {
 
 	client := &http.Client{}
 
 	httpTransportSettings := &http.Transport{}
 
 	if timeout > 0 {
+		httpTransportSettings.DialContext = (&net.Dialer{
+			Timeout:   time.Second * time.Duration(timeout),
+			KeepAlive: 30 * time.Second,
+		}).DialContext
+		httpTransportSettings.TLSHandshakeTimeout = time.Second * time.Duration(timeout)
 		httpTransportSettings.ResponseHeaderTimeout = time.Second * time.Duration(timeout)
 	}
 
+	if tlsConfig != nil {
+		httpTransportSettings.TLSClientConfig = tlsConfig
+	}
+
 	client.Transport = httpTransportSettings
 
 	return *client, nil
 }
 
+// getTLSConfig returns the TLS configuration of the tls settings, nil when
+// they are not set. The certificate authorities of tlsCaCert are trusted in
+// addition to the system ones
+func getTLSConfig(s *Settings) (*tls.Config, error) {
+
+	if s.TLSCaCert == "" && s.TLSClientCert == "" && s.TLSClientKey == "" && s.TLSServerName == "" && !s.TLSSkipVerify {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         s.TLSServerName,
+		InsecureSkipVerify: s.TLSSkipVerify,
+	}
+
+	if s.TLSCaCert != "" {
+		caCert, err := readPEM("tlsCaCert", s.TLSCaCert)
+		if err != nil {
+			return nil, err
+		}
+
+		caCertPool, err := x509.SystemCertPool()
+		if err != nil || caCertPool == nil {
+			caCertPool = x509.NewCertPool()
+		}
+		if !caCertPool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("'tlsCaCert' does not contain any PEM certificate")
+		}
+		tlsConfig.RootCAs = caCertPool
+	}
+
+	if s.TLSClientCert != "" || s.TLSClientKey != "" {
+		if s.TLSClientCert == "" || s.TLSClientKey == "" {
+			return nil, fmt.Errorf("'tlsClientCert' and 'tlsClientKey' are both required for client certificates")
+		}
+
+		certPEM, err := readPEM("tlsClientCert", s.TLSClientCert)
+		if err != nil {
+			return nil, err
+		}
+		keyPEM, err := readPEM("tlsClientKey", s.TLSClientKey)
+		if err != nil {
+			return nil, err
+		}
+
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("invalid client certificate: %s", err.Error())
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// readPEM returns the PEM data of a setting, either inline or in the file the
+// setting names
+func readPEM(name string, value string) ([]byte, error) {
+
+	if strings.HasPrefix(strings.TrimSpace(value), "-----BEGIN") {
+		return []byte(value), nil
+	}
+
+	data, err := ioutil.ReadFile(value)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read '%s' file: %s", name, err.Error())
+	}
+
+	return data, nil
+}
+
 func getRestResponse(client http.Client, method string, uri string, headers map[string]string, reqBody io.Reader) (*http.Response, error) {
 
 	req, err := http.NewRequest(method, uri, reqBody)
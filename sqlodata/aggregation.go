@@ -0,0 +1,281 @@
+package sqlodata
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// aggregation describes the grouping and aggregates of a query, it is used to
+// evaluate the query client side when the server does not support $apply
+type aggregation struct {
+	groupBy    []string
+	aggregates []*aggregateColumn
+	where      string
+	having     Expression
+	params     map[string]interface{}
+	aliases    map[string]string
+	orderBy    []sortKey
+	fields     []string
+	clientOnly bool
+}
+
+// aggregateColumn is an aggregate computed by the query, hidden aggregates are
+// only referenced by the having clause and are removed from the results
+type aggregateColumn struct {
+	fn       string
+	column   string
+	distinct bool
+	alias    string
+	hidden   bool
+}
+
+// expr returns the aggregate as written in a query
+func (column *aggregateColumn) expr() *AggregateExpr {
+
+	e := &AggregateExpr{Func: column.fn, Distinct: column.distinct}
+	if column.column != "" {
+		e.Column = &ColumnRef{Name: column.column}
+	}
+	return e
+}
+
+type sortKey struct {
+	name string
+	desc bool
+}
+
+type accumulator struct {
+	count    int
+	numbers  int
+	sum      float64
+	min      interface{}
+	max      interface{}
+	distinct map[string]bool
+}
+
+func (acc *accumulator) add(value interface{}) {
+
+	if value == nil {
+		return
+	}
+
+	acc.count++
+
+	if f, ok := toFloat(value); ok {
+		acc.numbers++
+		acc.sum += f
+	}
+
+	if acc.min == nil {
+		acc.min = value
+		acc.max = value
+	} else {
+		if c, ok := compareValues(value, acc.min); ok && c < 0 {
+			acc.min = value
+		}
+		if c, ok := compareValues(value, acc.max); ok && c > 0 {
+			acc.max = value
+		}
+	}
+
+	if acc.distinct != nil {
+		key, _ := json.Marshal(value)
+		acc.distinct[string(key)] = true
+	}
+}
+
+func (acc *accumulator) result(column *aggregateColumn, rows int) interface{} {
+
+	switch column.fn {
+	case kwCount:
+		if column.column == "" {
+			return float64(rows)
+		}
+		if column.distinct {
+			return float64(len(acc.distinct))
+		}
+		return float64(acc.count)
+	case kwSum:
+		if acc.numbers == 0 {
+			return nil
+		}
+		return acc.sum
+	case kwAvg:
+		if acc.numbers == 0 {
+			return nil
+		}
+		return acc.sum / float64(acc.numbers)
+	case kwMin:
+		return acc.min
+	case kwMax:
+		return acc.max
+	}
+	return nil
+}
+
+type group struct {
+	values       []interface{}
+	rows         int
+	accumulators []*accumulator
+}
+
+// aggregate groups the rows fetched from the server and computes the
+// aggregates, the having clause, orderby, top and skip are then applied to
+// the grouped results
+func (plan *aggregation) aggregate(rows []interface{}, top *int, skip *int) ([]interface{}, error) {
+
+	var groups []*group
+	groupIndex := make(map[string]*group)
+
+	newGroup := func(values []interface{}) *group {
+		g := &group{values: values, accumulators: make([]*accumulator, len(plan.aggregates))}
+		for i, column := range plan.aggregates {
+			g.accumulators[i] = &accumulator{}
+			if column.distinct {
+				g.accumulators[i].distinct = make(map[string]bool)
+			}
+		}
+		groups = append(groups, g)
+		return g
+	}
+
+	for _, r := range rows {
+		row, ok := r.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		values := make([]interface{}, len(plan.groupBy))
+		for i, column := range plan.groupBy {
+			values[i], _ = lookupField(row, column)
+		}
+		keyBytes, err := json.Marshal(values)
+		if err != nil {
+			return nil, err
+		}
+		key := string(keyBytes)
+
+		g, ok := groupIndex[key]
+		if !ok {
+			g = newGroup(values)
+			groupIndex[key] = g
+		}
+
+		g.rows++
+		for i, column := range plan.aggregates {
+			if column.column != "" {
+				value, _ := lookupField(row, column.column)
+				g.accumulators[i].add(value)
+			}
+		}
+	}
+
+	// without group by an aggregate query always returns a single row
+	if len(groups) == 0 && len(plan.groupBy) == 0 {
+		newGroup(nil)
+	}
+
+	evaluator := &rowEvaluator{params: plan.params, aliases: plan.aliases}
+
+	var results []map[string]interface{}
+	for _, g := range groups {
+		result := make(map[string]interface{})
+		for i, column := range plan.groupBy {
+			result[column] = g.values[i]
+		}
+		for i, column := range plan.aggregates {
+			result[column.alias] = g.accumulators[i].result(column, g.rows)
+		}
+
+		if plan.having != nil {
+			ok, err := evaluator.test(plan.having, result)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+		}
+
+		results = append(results, result)
+	}
+
+	sortRows(results, plan.orderBy)
+
+	page := pageRows(results, top, skip)
+
+	out := make([]interface{}, len(page))
+	for i, result := range page {
+		out[i] = result
+	}
+	plan.removeHidden(out)
+
+	return out, nil
+}
+
+// removeHidden removes the aggregates only computed for the having clause
+func (plan *aggregation) removeHidden(results []interface{}) {
+
+	for _, column := range plan.aggregates {
+		if !column.hidden {
+			continue
+		}
+		for _, r := range results {
+			if row, ok := r.(map[string]interface{}); ok {
+				delete(row, column.alias)
+			}
+		}
+	}
+}
+
+// sortRows orders rows by the sort keys, nulls sort first
+func sortRows(rows []map[string]interface{}, keys []sortKey) {
+
+	if len(keys) == 0 {
+		return
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		for _, key := range keys {
+			left, _ := lookupField(rows[i], key.name)
+			right, _ := lookupField(rows[j], key.name)
+
+			var c int
+			switch {
+			case left == nil && right == nil:
+				c = 0
+			case left == nil:
+				c = -1
+			case right == nil:
+				c = 1
+			default:
+				c, _ = compareValues(left, right)
+			}
+
+			if c != 0 {
+				if key.desc {
+					return c > 0
+				}
+				return c < 0
+			}
+		}
+		return false
+	})
+}
+
+// pageRows applies top and skip to rows computed client side
+func pageRows(rows []map[string]interface{}, top *int, skip *int) []map[string]interface{} {
+
+	if skip != nil {
+		if *skip >= len(rows) {
+			return nil
+		}
+		rows = rows[*skip:]
+	}
+
+	if top != nil && *top < len(rows) {
+		rows = rows[:*top]
+	}
+
+	return rows
+}
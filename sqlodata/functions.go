@@ -0,0 +1,266 @@
+package sqlodata
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// valueType is the type of a function argument or result, typeAny is used
+// when the type is only known once the query runs, e.g. for columns
+type valueType int
+
+const (
+	typeAny valueType = iota
+	typeString
+	typeNumber
+	typeBool
+	typeDate
+)
+
+func (t valueType) String() string {
+
+	switch t {
+	case typeString:
+		return "string"
+	case typeNumber:
+		return "number"
+	case typeBool:
+		return "boolean"
+	case typeDate:
+		return "date"
+	}
+	return "any"
+}
+
+// function describes one of the OData canonical functions, the last
+// len(args) - minArgs arguments are optional
+type function struct {
+	name    string
+	args    []valueType
+	minArgs int
+	result  valueType
+}
+
+// functions maps the function names accepted in queries to the OData
+// canonical functions, the SQL names upper and lower are accepted as well
+var functions = map[string]*function{
+	"tolower":   {name: "tolower", args: []valueType{typeString}, minArgs: 1, result: typeString},
+	"toupper":   {name: "toupper", args: []valueType{typeString}, minArgs: 1, result: typeString},
+	"lower":     {name: "tolower", args: []valueType{typeString}, minArgs: 1, result: typeString},
+	"upper":     {name: "toupper", args: []valueType{typeString}, minArgs: 1, result: typeString},
+	"trim":      {name: "trim", args: []valueType{typeString}, minArgs: 1, result: typeString},
+	"length":    {name: "length", args: []valueType{typeString}, minArgs: 1, result: typeNumber},
+	"substring": {name: "substring", args: []valueType{typeString, typeNumber, typeNumber}, minArgs: 2, result: typeString},
+	"indexof":   {name: "indexof", args: []valueType{typeString, typeString}, minArgs: 2, result: typeNumber},
+	"year":      {name: "year", args: []valueType{typeDate}, minArgs: 1, result: typeNumber},
+	"month":     {name: "month", args: []valueType{typeDate}, minArgs: 1, result: typeNumber},
+	"day":       {name: "day", args: []valueType{typeDate}, minArgs: 1, result: typeNumber},
+	"hour":      {name: "hour", args: []valueType{typeDate}, minArgs: 1, result: typeNumber},
+	"round":     {name: "round", args: []valueType{typeNumber}, minArgs: 1, result: typeNumber},
+	"floor":     {name: "floor", args: []valueType{typeNumber}, minArgs: 1, result: typeNumber},
+	"ceiling":   {name: "ceiling", args: []valueType{typeNumber}, minArgs: 1, result: typeNumber},
+}
+
+// exprType returns the type of an expression when it is known before the
+// query runs
+func exprType(expr Expression) valueType {
+
+	switch e := expr.(type) {
+	case *Literal:
+		switch e.Kind {
+		case LiteralString:
+			return typeString
+		case LiteralNumber:
+			return typeNumber
+		case LiteralBool:
+			return typeBool
+		case LiteralDate, LiteralTimestamp:
+			return typeDate
+		}
+	case *Parameter:
+		if e.Kind == LiteralDate || e.Kind == LiteralTimestamp {
+			return typeDate
+		}
+	case *FunctionCall:
+		return e.fn.result
+	}
+	return typeAny
+}
+
+// valueTypeOf returns the type of a parameter value
+func valueTypeOf(value interface{}) valueType {
+
+	switch value.(type) {
+	case nil:
+		return typeAny
+	case time.Time, *time.Time:
+		return typeDate
+	}
+
+	if _, ok := toFloat(value); ok {
+		return typeNumber
+	}
+
+	switch reflect.ValueOf(value).Kind() {
+	case reflect.String:
+		return typeString
+	case reflect.Bool:
+		return typeBool
+	}
+	return typeAny
+}
+
+// checkArgs checks the number of arguments of a function call and the types
+// of the arguments whose type is known, it describes the first problem found
+// or returns an empty string
+func checkArgs(call *FunctionCall) string {
+
+	if len(call.Args) < call.fn.minArgs || len(call.Args) > len(call.fn.args) {
+		if call.fn.minArgs == len(call.fn.args) {
+			return fmt.Sprintf("%s expects %d argument(s), found %d", call.Name, call.fn.minArgs, len(call.Args))
+		}
+		return fmt.Sprintf("%s expects %d to %d arguments, found %d", call.Name, call.fn.minArgs, len(call.fn.args), len(call.Args))
+	}
+
+	for i, arg := range call.Args {
+		if literal, ok := arg.(*Literal); ok && literal.Kind == LiteralString && call.fn.args[i] == typeDate && isDateString(literal.Value) {
+			continue
+		}
+		if t := exprType(arg); t != typeAny && t != call.fn.args[i] {
+			return fmt.Sprintf("argument %d of %s must be a %s, found %s", i+1, call.Name, call.fn.args[i], t)
+		}
+	}
+
+	return ""
+}
+
+// evalFunction computes a function client side, null arguments and values of
+// the wrong type result in null
+func evalFunction(fn *function, args []interface{}) interface{} {
+
+	for _, arg := range args {
+		if arg == nil {
+			return nil
+		}
+	}
+
+	switch fn.name {
+	case "tolower", "toupper", "trim", "length", "substring", "indexof":
+		str, ok := args[0].(string)
+		if !ok {
+			return nil
+		}
+		switch fn.name {
+		case "tolower":
+			return strings.ToLower(str)
+		case "toupper":
+			return strings.ToUpper(str)
+		case "trim":
+			return strings.TrimSpace(str)
+		case "length":
+			return float64(utf8.RuneCountInString(str))
+		case "substring":
+			return substring(str, args[1:])
+		case "indexof":
+			sub, ok := args[1].(string)
+			if !ok {
+				return nil
+			}
+			i := strings.Index(str, sub)
+			if i < 0 {
+				return float64(-1)
+			}
+			return float64(utf8.RuneCountInString(str[:i]))
+		}
+	case "year", "month", "day", "hour":
+		t, ok := toTime(args[0])
+		if !ok {
+			return nil
+		}
+		switch fn.name {
+		case "year":
+			return float64(t.Year())
+		case "month":
+			return float64(t.Month())
+		case "day":
+			return float64(t.Day())
+		case "hour":
+			return float64(t.Hour())
+		}
+	case "round", "floor", "ceiling":
+		f, ok := toFloat(args[0])
+		if !ok {
+			return nil
+		}
+		switch fn.name {
+		case "round":
+			return math.Round(f)
+		case "floor":
+			return math.Floor(f)
+		case "ceiling":
+			return math.Ceil(f)
+		}
+	}
+
+	return nil
+}
+
+// substring returns the characters of str from a zero based start position,
+// optionally limited to a number of characters
+func substring(str string, args []interface{}) interface{} {
+
+	runes := []rune(str)
+
+	start, ok := toFloat(args[0])
+	if !ok {
+		return nil
+	}
+	from := int(math.Max(0, math.Min(start, float64(len(runes)))))
+
+	to := len(runes)
+	if len(args) > 1 {
+		length, ok := toFloat(args[1])
+		if !ok {
+			return nil
+		}
+		if end := float64(from) + math.Max(0, length); end < float64(to) {
+			to = int(end)
+		}
+	}
+
+	return string(runes[from:to])
+}
+
+// isDateString reports whether a string is an ISO 8601 date or date time,
+// such strings are accepted where a function expects a date
+func isDateString(value string) bool {
+	_, ok := toTime(value)
+	return ok
+}
+
+// toTime converts a date value, either a time.Time or an ISO 8601 string as
+// returned by the server
+func toTime(value interface{}) (time.Time, bool) {
+
+	switch v := value.(type) {
+	case time.Time:
+		return v, true
+	case *time.Time:
+		if v == nil {
+			return time.Time{}, false
+		}
+		return *v, true
+	case string:
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999", "2006-01-02"} {
+			if t, err := time.Parse(layout, v); err == nil {
+				return t, true
+			}
+		}
+	}
+
+	return time.Time{}, false
+}
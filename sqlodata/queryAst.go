@@ -0,0 +1,329 @@
+package sqlodata
+
+import "strings"
+
+// selectStatement is the root of the syntax tree produced by the query parser
+type selectStatement struct {
+	distinct  bool
+	all       bool
+	items     []*selectItem
+	top       Expression
+	skip      Expression
+	from      string
+	fromAlias string
+	joins     []*join
+	where     Expression
+	groupBy   []*ColumnRef
+	having    Expression
+	orderBy   []*orderByItem
+}
+
+// join is a related entity read with each row, name is the navigation property
+// of the from table. on is nil for entities listed in an expand clause
+type join struct {
+	name  string
+	alias string
+	on    Expression
+	pos   int
+}
+
+// selectItem is an entry of the select list, either a *ColumnRef, an
+// *AggregateExpr or a *FunctionCall, aggregates and functions are always given
+// an alias
+type selectItem struct {
+	expr  Expression
+	alias string
+}
+
+// orderByItem is a sort column, the direction is either written in the query
+// or taken from directionParam when the query binds it to a parameter
+type orderByItem struct {
+	column         *ColumnRef
+	direction      string
+	directionParam *Parameter
+}
+
+// Expression is implemented by all nodes that can appear in a where clause
+type Expression interface {
+	exprNode()
+}
+
+// LogicalExpr combines two conditions with 'and' or 'or'
+type LogicalExpr struct {
+	Op    string
+	Left  Expression
+	Right Expression
+}
+
+// NotExpr negates a condition
+type NotExpr struct {
+	Expr Expression
+}
+
+// ComparisonExpr compares two operands, Op is the operator as written in the query
+type ComparisonExpr struct {
+	Op    string
+	Left  Expression
+	Right Expression
+}
+
+// InExpr tests an operand against a list of values, 'expr [not] in (a, b)'
+type InExpr struct {
+	Expr   Expression
+	Values []Expression
+	Not    bool
+}
+
+// BetweenExpr tests an operand against an inclusive range, 'expr [not] between low and high'
+type BetweenExpr struct {
+	Expr Expression
+	Low  Expression
+	High Expression
+	Not  bool
+}
+
+// LikeExpr matches an operand against a pattern using '%' as wildcard, 'expr [not] like pattern'
+type LikeExpr struct {
+	Expr    Expression
+	Pattern Expression
+	Not     bool
+}
+
+// IsNullExpr tests an operand for null, 'expr is [not] null'
+type IsNullExpr struct {
+	Expr Expression
+	Not  bool
+}
+
+// ColumnRef is a column, Table is the table name or alias it is qualified with.
+// pos is the position of the column in the query, as for the other nodes
+// below that have one, and is only used to report errors
+type ColumnRef struct {
+	Table string
+	Name  string
+	pos   int
+}
+
+// AggregateExpr is a call to one of the aggregate functions count, sum, avg,
+// min or max, Column is nil for count(*)
+type AggregateExpr struct {
+	Func     string
+	Column   *ColumnRef
+	Distinct bool
+	pos      int
+}
+
+// key identifies the aggregate independent of the case it was written in
+func (e *AggregateExpr) key() string {
+
+	if e.Column == nil {
+		return e.Func + "(*)"
+	}
+	if e.Distinct {
+		return e.Func + "(distinct " + strings.ToLower(e.Column.Name) + ")"
+	}
+	return e.Func + "(" + strings.ToLower(e.Column.Name) + ")"
+}
+
+// FunctionCall is a call to one of the OData canonical functions, Name is the
+// function name as written in the query
+type FunctionCall struct {
+	fn   *function
+	Name string
+	Args []Expression
+	pos  int
+}
+
+// LiteralKind is the type of the value of a Literal
+type LiteralKind int
+
+// The kinds of literals, a string, a number, true or false, null, and the
+// typed literals date '2025-01-31', timestamp '2025-01-31T10:00:00Z' and
+// guid 'c56a4180-65aa-42ec-a945-5fd21dec0538'
+const (
+	LiteralString LiteralKind = iota
+	LiteralNumber
+	LiteralBool
+	LiteralNull
+	LiteralDate
+	LiteralTimestamp
+	LiteralGuid
+)
+
+// Literal is a value written in the query, Value is the text of a number,
+// true, false or null, or the content of a string without quotes
+type Literal struct {
+	Kind  LiteralKind
+	Value string
+}
+
+// Parameter is a reference to an input parameter, ':name'. Kind is
+// LiteralDate, LiteralTimestamp or LiteralGuid for a typed parameter such as
+// 'date :name', and LiteralString for any other parameter
+type Parameter struct {
+	Name string
+	Kind LiteralKind
+}
+
+func (*LogicalExpr) exprNode()    {}
+func (*NotExpr) exprNode()        {}
+func (*ComparisonExpr) exprNode() {}
+func (*InExpr) exprNode()         {}
+func (*BetweenExpr) exprNode()    {}
+func (*LikeExpr) exprNode()       {}
+func (*IsNullExpr) exprNode()     {}
+func (*ColumnRef) exprNode()      {}
+func (*AggregateExpr) exprNode()  {}
+func (*FunctionCall) exprNode()   {}
+func (*Literal) exprNode()        {}
+func (*Parameter) exprNode()      {}
+
+// hasParams reports whether any part of the statement references a parameter
+func (stmt *selectStatement) hasParams() bool {
+
+	for _, item := range stmt.orderBy {
+		if item.directionParam != nil {
+			return true
+		}
+	}
+
+	for _, j := range stmt.joins {
+		if referencesParams(j.on) {
+			return true
+		}
+	}
+
+	for _, item := range stmt.items {
+		if referencesParams(item.expr) {
+			return true
+		}
+	}
+
+	return referencesParams(stmt.top) || referencesParams(stmt.skip) ||
+		referencesParams(stmt.where) || referencesParams(stmt.having)
+}
+
+// isAggregate reports whether the statement groups rows or computes aggregates
+func (stmt *selectStatement) isAggregate() bool {
+
+	if len(stmt.groupBy) > 0 || stmt.having != nil {
+		return true
+	}
+
+	for _, item := range stmt.items {
+		if _, ok := item.expr.(*AggregateExpr); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasFunctions reports whether the select list computes functions
+func (stmt *selectStatement) hasFunctions() bool {
+
+	for _, item := range stmt.items {
+		if _, ok := item.expr.(*FunctionCall); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// joinOf returns the join a column is qualified with, or nil for the columns
+// of the from table
+func (stmt *selectStatement) joinOf(column *ColumnRef) *join {
+
+	if column.Table == "" {
+		return nil
+	}
+
+	for _, j := range stmt.joins {
+		if strings.EqualFold(column.Table, j.name) || strings.EqualFold(column.Table, j.alias) {
+			return j
+		}
+	}
+
+	return nil
+}
+
+func referencesParams(expr Expression) bool {
+
+	found := false
+	walkExpr(expr, func(e Expression) {
+		if _, ok := e.(*Parameter); ok {
+			found = true
+		}
+	})
+
+	return found
+}
+
+// exprLocation returns the position and text of the first column, aggregate
+// or function of expr, the position is -1 if expr has none
+func exprLocation(expr Expression) (int, string) {
+
+	pos, text := -1, ""
+	walkExpr(expr, func(e Expression) {
+		if pos >= 0 {
+			return
+		}
+		switch x := e.(type) {
+		case *ColumnRef:
+			pos, text = x.pos, x.Name
+			if x.Table != "" {
+				text = x.Table + "." + x.Name
+			}
+		case *AggregateExpr:
+			pos, text = x.pos, x.Func
+		case *FunctionCall:
+			pos, text = x.pos, x.Name
+		}
+	})
+
+	return pos, text
+}
+
+// walkExpr calls fn for expr and all of its operands
+func walkExpr(expr Expression, fn func(Expression)) {
+
+	if expr == nil {
+		return
+	}
+
+	fn(expr)
+
+	switch e := expr.(type) {
+	case *LogicalExpr:
+		walkExpr(e.Left, fn)
+		walkExpr(e.Right, fn)
+	case *NotExpr:
+		walkExpr(e.Expr, fn)
+	case *ComparisonExpr:
+		walkExpr(e.Left, fn)
+		walkExpr(e.Right, fn)
+	case *InExpr:
+		walkExpr(e.Expr, fn)
+		for _, value := range e.Values {
+			walkExpr(value, fn)
+		}
+	case *BetweenExpr:
+		walkExpr(e.Expr, fn)
+		walkExpr(e.Low, fn)
+		walkExpr(e.High, fn)
+	case *LikeExpr:
+		walkExpr(e.Expr, fn)
+		walkExpr(e.Pattern, fn)
+	case *IsNullExpr:
+		walkExpr(e.Expr, fn)
+	case *AggregateExpr:
+		if e.Column != nil {
+			walkExpr(e.Column, fn)
+		}
+	case *FunctionCall:
+		for _, arg := range e.Args {
+			walkExpr(arg, fn)
+		}
+	}
+}
@@ -0,0 +1,927 @@
+package sqlodata
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// buildQuery generates the OData request from the syntax tree
+func buildQuery(stmt *selectStatement, params map[string]interface{}) (*ODataRequest, error) {
+
+	request := &ODataRequest{
+		Entity:   stmt.from,
+		Where:    stmt.where,
+		Distinct: stmt.distinct,
+	}
+
+	b := &queryBuilder{params: params}
+
+	if stmt.all {
+		request.Select = []string{allColumns}
+	} else if !stmt.isAggregate() {
+		columns := selectedColumns(stmt)
+		if stmt.hasFunctions() {
+			var err error
+			request.computation, columns, err = b.buildComputation(stmt, columns)
+			if err != nil {
+				return nil, err
+			}
+		}
+		request.Select = columns
+	}
+
+	request.aliases = columnAliases(stmt)
+
+	if stmt.top != nil {
+		top, err := b.buildCount(kwTop, stmt.top)
+		if err != nil {
+			return nil, err
+		}
+		request.Top = &top
+	}
+	if stmt.skip != nil {
+		skip, err := b.buildCount(kwSkip, stmt.skip)
+		if err != nil {
+			return nil, err
+		}
+		request.Skip = &skip
+	}
+
+	if stmt.where != nil {
+		filter, err := b.build(stmt.where)
+		if err != nil {
+			return nil, err
+		}
+
+		request.Filter = filter
+	}
+
+	if len(stmt.joins) > 0 {
+		expand, err := b.buildExpand(stmt)
+		if err != nil {
+			return nil, err
+		}
+		request.Expand = expand
+	}
+
+	// select distinct columns is the same as grouping by the columns, while
+	// select distinct * is de-duplicated client side
+	if stmt.isAggregate() || (stmt.distinct && !stmt.all) {
+		err := b.buildAggregation(stmt, request)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, item := range stmt.orderBy {
+		direction, err := b.buildDirection(item)
+		if err != nil {
+			return nil, err
+		}
+		orderby := item.column.Name
+		if direction != "" {
+			orderby += " " + direction
+		}
+		request.OrderBy = append(request.OrderBy, orderby)
+		if request.aggregation != nil {
+			request.aggregation.orderBy = append(request.aggregation.orderBy, sortKey{name: item.column.Name, desc: direction == kwDesc})
+		}
+	}
+
+	return request, nil
+}
+
+// selectedColumns lists the names of the columns of the from table selected
+// by a query without aggregates, each column is only listed once
+func selectedColumns(stmt *selectStatement) []string {
+
+	var columnNames []string
+	seen := make(map[string]bool)
+	for _, item := range stmt.items {
+		column, ok := item.expr.(*ColumnRef)
+		if !ok || stmt.joinOf(column) != nil {
+			continue
+		}
+		name := column.Name
+		if !seen[strings.ToLower(name)] {
+			seen[strings.ToLower(name)] = true
+			columnNames = append(columnNames, name)
+		}
+	}
+
+	return columnNames
+}
+
+// columnAliases lists the selected columns that are renamed in the results
+func columnAliases(stmt *selectStatement) []columnAlias {
+
+	var aliases []columnAlias
+	keep := make(map[string]bool)
+
+	for _, item := range stmt.items {
+		if column, ok := item.expr.(*ColumnRef); ok && stmt.joinOf(column) == nil {
+			if item.alias == "" {
+				keep[strings.ToLower(column.Name)] = true
+			} else if item.alias != column.Name {
+				aliases = append(aliases, columnAlias{field: column.Name, alias: item.alias})
+			}
+		}
+	}
+
+	for i := range aliases {
+		aliases[i].keep = keep[strings.ToLower(aliases[i].field)]
+	}
+
+	return aliases
+}
+
+// queryBuilder renders the parts of a syntax tree as OData query options,
+// binding the parameters referenced by the query to their values in params
+type queryBuilder struct {
+	params  map[string]interface{}
+	aliases map[string]string
+	// outer is set while rendering the on clause of a join, the columns of
+	// the from table are written as $it/name
+	outer *selectStatement
+}
+
+// hiddenAliasPrefix starts the aliases of the aggregates only used in having
+const hiddenAliasPrefix = "_having"
+
+// buildAggregation renders a grouping query as an OData $apply transformation,
+// the where clause becomes a filter applied before grouping while the having
+// clause becomes the $filter applied to the grouped results
+func (b *queryBuilder) buildAggregation(stmt *selectStatement, request *ODataRequest) error {
+
+	plan := &aggregation{
+		where:  request.Filter,
+		having: stmt.having,
+		params: b.params,
+	}
+
+	for _, column := range stmt.groupBy {
+		plan.groupBy = append(plan.groupBy, column.Name)
+	}
+	if !stmt.isAggregate() {
+		plan.groupBy = selectedColumns(stmt)
+	}
+
+	b.aliases = make(map[string]string)
+	addAggregate := func(e *AggregateExpr, alias string, hidden bool) {
+		if _, ok := b.aliases[e.key()]; !ok {
+			b.aliases[e.key()] = alias
+		}
+		column := &aggregateColumn{fn: e.Func, distinct: e.Distinct, alias: alias, hidden: hidden}
+		if e.Column != nil {
+			column.column = e.Column.Name
+		}
+		plan.aggregates = append(plan.aggregates, column)
+	}
+
+	for _, item := range stmt.items {
+		if e, ok := item.expr.(*AggregateExpr); ok {
+			addAggregate(e, item.alias, false)
+		}
+	}
+
+	// aggregates only used in having are computed under a generated alias and
+	// removed from the results
+	walkExpr(stmt.having, func(expr Expression) {
+		if e, ok := expr.(*AggregateExpr); ok {
+			if _, found := b.aliases[e.key()]; !found {
+				addAggregate(e, fmt.Sprintf("%s%d", hiddenAliasPrefix, len(b.aliases)+1), true)
+			}
+		}
+	})
+
+	var aggregates []string
+	for _, column := range plan.aggregates {
+		switch {
+		case column.column == "":
+			aggregates = append(aggregates, "$count as "+column.alias)
+		case column.distinct:
+			aggregates = append(aggregates, fmt.Sprintf("%s with countdistinct as %s", column.column, column.alias))
+		case column.fn == kwCount:
+			// counting the non null values of a column has no OData equivalent
+			plan.clientOnly = true
+		default:
+			aggregates = append(aggregates, fmt.Sprintf("%s with %s as %s", column.column, aggregateMap[column.fn], column.alias))
+		}
+	}
+
+	plan.aliases = b.aliases
+	plan.fields = aggregationFields(plan)
+	request.aggregation = plan
+	request.Select = nil
+	request.Filter = ""
+
+	// the having clause is also built for client side plans, which evaluate
+	// it, to check its parameters
+	having := ""
+	if stmt.having != nil {
+		var err error
+		having, err = b.build(stmt.having)
+		if err != nil {
+			return err
+		}
+	}
+
+	if plan.clientOnly {
+		// the request is only sent as its Fallback, which reads the rows
+		return nil
+	}
+
+	var apply []string
+	if plan.where != "" {
+		apply = append(apply, "filter("+plan.where+")")
+	}
+	switch {
+	case len(plan.groupBy) > 0 && len(aggregates) > 0:
+		apply = append(apply, fmt.Sprintf("groupby((%s),aggregate(%s))", strings.Join(plan.groupBy, ","), strings.Join(aggregates, ",")))
+	case len(plan.groupBy) > 0:
+		apply = append(apply, fmt.Sprintf("groupby((%s))", strings.Join(plan.groupBy, ",")))
+	default:
+		apply = append(apply, fmt.Sprintf("aggregate(%s)", strings.Join(aggregates, ",")))
+	}
+
+	request.Apply = strings.Join(apply, "/")
+	request.Filter = having
+
+	return nil
+}
+
+// buildComputation lists the functions of the select list, which OData cannot
+// return, so they are computed from the results. The columns the functions use
+// are added to the selected columns and removed once the functions are computed
+func (b *queryBuilder) buildComputation(stmt *selectStatement, columns []string) (*computation, []string, error) {
+
+	c := &computation{params: b.params}
+
+	selected := make(map[string]bool)
+	for _, name := range columns {
+		selected[strings.ToLower(name)] = true
+	}
+
+	for _, item := range stmt.items {
+		call, ok := item.expr.(*FunctionCall)
+		if !ok {
+			continue
+		}
+
+		err := b.checkParams(call)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		walkExpr(call, func(expr Expression) {
+			if column, ok := expr.(*ColumnRef); ok && !selected[strings.ToLower(column.Name)] {
+				selected[strings.ToLower(column.Name)] = true
+				columns = append(columns, column.Name)
+				c.hidden = append(c.hidden, column.Name)
+			}
+		})
+
+		c.columns = append(c.columns, &computedColumn{alias: item.alias, expr: call})
+	}
+
+	return c, columns, nil
+}
+
+// checkParams checks the types of the parameters passed to a function, the
+// types of the other arguments are checked when the query is parsed
+func (b *queryBuilder) checkParams(call *FunctionCall) error {
+
+	for i, arg := range call.Args {
+		switch e := arg.(type) {
+		case *Parameter:
+			if e.Kind != LiteralString {
+				// the values of typed parameters are checked when formatted
+				continue
+			}
+			value, err := b.param(e.Name)
+			if err != nil {
+				return err
+			}
+			if str, ok := value.(string); ok && call.fn.args[i] == typeDate && isDateString(str) {
+				continue
+			}
+			if t := valueTypeOf(value); t != typeAny && t != call.fn.args[i] {
+				return fmt.Errorf("invalid query: argument %d of %s must be a %s, input param '%s' is %T", i+1, call.Name, call.fn.args[i], e.Name, value)
+			}
+		case *FunctionCall:
+			err := b.checkParams(e)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// buildExpand renders the joins as $expand, the columns selected from a joined
+// entity and its on clause become its $select and $filter
+func (b *queryBuilder) buildExpand(stmt *selectStatement) (string, error) {
+
+	expand := make([]string, len(stmt.joins))
+	for i, j := range stmt.joins {
+		var columns []string
+		seen := make(map[string]bool)
+		for _, item := range stmt.items {
+			column := item.expr.(*ColumnRef)
+			if stmt.joinOf(column) == j && !seen[strings.ToLower(column.Name)] {
+				seen[strings.ToLower(column.Name)] = true
+				columns = append(columns, column.Name)
+			}
+		}
+
+		var options []string
+		if len(columns) > 0 {
+			options = append(options, "$select="+strings.Join(columns, ","))
+		}
+		if j.on != nil {
+			b.outer = stmt
+			filter, err := b.build(j.on)
+			b.outer = nil
+			if err != nil {
+				return "", err
+			}
+			options = append(options, "$filter="+filter)
+		}
+
+		expand[i] = j.name
+		if len(options) > 0 {
+			expand[i] += "(" + strings.Join(options, ";") + ")"
+		}
+	}
+
+	return strings.Join(expand, ","), nil
+}
+
+// aggregationFields lists the columns to fetch when aggregating client side,
+// there are none when the query only counts rows
+func aggregationFields(plan *aggregation) []string {
+
+	var fields []string
+	seen := make(map[string]bool)
+	add := func(name string) {
+		if name != "" && !seen[strings.ToLower(name)] {
+			seen[strings.ToLower(name)] = true
+			fields = append(fields, name)
+		}
+	}
+
+	for _, column := range plan.groupBy {
+		add(column)
+	}
+	for _, column := range plan.aggregates {
+		add(column.column)
+	}
+
+	return fields
+}
+
+func (b *queryBuilder) buildCount(keyword string, expr Expression) (int, error) {
+
+	switch e := expr.(type) {
+	case *Literal:
+		count, err := strconv.Atoi(e.Value)
+		if err != nil {
+			return 0, fmt.Errorf("invalid query: invalid value %s '%s'", keyword, e.Value)
+		}
+		return count, nil
+	case *Parameter:
+		value, err := b.param(e.Name)
+		if err != nil {
+			return 0, err
+		}
+		count, err := toCount(value)
+		if err != nil {
+			return 0, fmt.Errorf("invalid query: invalid value %s, input param '%s' %s", keyword, e.Name, err.Error())
+		}
+		return int(count), nil
+	}
+
+	return 0, fmt.Errorf("invalid query: invalid value %s", keyword)
+}
+
+// toCount converts a top or skip parameter value to a non-negative integer
+func toCount(value interface{}) (int64, error) {
+
+	var count int64
+
+	switch v := value.(type) {
+	case json.Number:
+		i, err := v.Int64()
+		if err != nil {
+			return 0, fmt.Errorf("is not an integer '%s'", v)
+		}
+		count = i
+	case string:
+		i, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("is not an integer '%s'", v)
+		}
+		count = i
+	default:
+		rv := reflect.ValueOf(value)
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			count = rv.Int()
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if rv.Uint() > math.MaxInt64 {
+				return 0, fmt.Errorf("is too large")
+			}
+			count = int64(rv.Uint())
+		case reflect.Float32, reflect.Float64:
+			f := rv.Float()
+			if f != math.Trunc(f) || math.IsInf(f, 0) || f > math.MaxInt64 {
+				return 0, fmt.Errorf("is not an integer '%v'", f)
+			}
+			count = int64(f)
+		default:
+			return 0, fmt.Errorf("has unsupported type %T", value)
+		}
+	}
+
+	if count < 0 {
+		return 0, fmt.Errorf("must not be negative")
+	}
+
+	return count, nil
+}
+
+func (b *queryBuilder) buildDirection(item *orderByItem) (string, error) {
+
+	if item.directionParam == nil {
+		return item.direction, nil
+	}
+
+	value, err := b.param(item.directionParam.Name)
+	if err != nil {
+		return "", err
+	}
+
+	direction, ok := value.(string)
+	direction = strings.ToLower(strings.TrimSpace(direction))
+	if !ok || (direction != "" && direction != kwAsc && direction != kwDesc) {
+		return "", fmt.Errorf("invalid query: input param '%s' must be '%s' or '%s' for orderby direction", item.directionParam.Name, kwAsc, kwDesc)
+	}
+
+	return direction, nil
+}
+
+const (
+	precedenceOr = iota + 1
+	precedenceAnd
+	precedenceNot
+	precedenceComparison
+)
+
+func precedence(expr Expression) int {
+
+	switch e := expr.(type) {
+	case *LogicalExpr:
+		if e.Op == kwOr {
+			return precedenceOr
+		}
+		return precedenceAnd
+	case *NotExpr:
+		return precedenceNot
+	}
+	return precedenceComparison
+}
+
+func (b *queryBuilder) build(expr Expression) (string, error) {
+
+	switch e := expr.(type) {
+	case *LogicalExpr:
+		left, err := b.buildOperand(e.Left, precedence(e))
+		if err != nil {
+			return "", err
+		}
+		right, err := b.buildOperand(e.Right, precedence(e))
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s %s %s", left, e.Op, right), nil
+	case *NotExpr:
+		// not binds tighter than the comparison operators in OData, so its
+		// operand is always grouped
+		operand, err := b.build(e.Expr)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("not (%s)", operand), nil
+	case *ComparisonExpr:
+		opStr, ok := opMap[e.Op]
+		if !ok {
+			return "", fmt.Errorf("invalid query: unknown operator '%s'", e.Op)
+		}
+		left, err := b.build(e.Left)
+		if err != nil {
+			return "", err
+		}
+		right, err := b.build(e.Right)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s %s %s", left, opStr, right), nil
+	case *InExpr:
+		return b.buildIn(e)
+	case *BetweenExpr:
+		return b.buildBetween(e)
+	case *LikeExpr:
+		return b.buildLike(e)
+	case *IsNullExpr:
+		operand, err := b.build(e.Expr)
+		if err != nil {
+			return "", err
+		}
+		if e.Not {
+			return operand + " ne null", nil
+		}
+		return operand + " eq null", nil
+	case *ColumnRef:
+		if b.outer != nil && b.outer.joinOf(e) == nil {
+			return "$it/" + e.Name, nil
+		}
+		return e.Name, nil
+	case *AggregateExpr:
+		alias, ok := b.aliases[e.key()]
+		if !ok {
+			return "", fmt.Errorf("invalid query: aggregates are only supported in select and having")
+		}
+		return alias, nil
+	case *FunctionCall:
+		err := b.checkParams(e)
+		if err != nil {
+			return "", err
+		}
+		args := make([]string, len(e.Args))
+		for i, arg := range e.Args {
+			if e.fn.args[i] == typeDate {
+				if date, ok := b.dateArg(arg); ok {
+					args[i] = date
+					continue
+				}
+			}
+			args[i], err = b.build(arg)
+			if err != nil {
+				return "", err
+			}
+		}
+		return fmt.Sprintf("%s(%s)", e.fn.name, strings.Join(args, ", ")), nil
+	case *Literal:
+		if e.Kind == LiteralString {
+			return quoteString(e.Value), nil
+		}
+		// numbers, true, false, null and the typed literals are written
+		// without quotes in OData 4
+		return e.Value, nil
+	case *Parameter:
+		value, err := b.param(e.Name)
+		if err != nil {
+			return "", err
+		}
+		if e.Kind != LiteralString {
+			return formatTypedValue(e.Name, e.Kind, value)
+		}
+		return formatValue(e.Name, value)
+	}
+
+	return "", fmt.Errorf("invalid query: unsupported expression in where clause")
+}
+
+func (b *queryBuilder) param(name string) (interface{}, error) {
+
+	value, ok := b.params[name]
+	if !ok {
+		return nil, fmt.Errorf("invalid query: input param '%s' is referenced by the query but was not provided", name)
+	}
+	return value, nil
+}
+
+// dateArg renders a string literal or parameter passed to a date function as
+// an OData date, or as a DateTimeOffset for date times, which are taken as UTC
+// when they have no offset. ok is false for other arguments
+func (b *queryBuilder) dateArg(arg Expression) (string, bool) {
+
+	var str string
+	switch e := arg.(type) {
+	case *Literal:
+		if e.Kind != LiteralString {
+			return "", false
+		}
+		str = e.Value
+	case *Parameter:
+		value, ok := b.params[e.Name].(string)
+		if e.Kind != LiteralString || !ok {
+			return "", false
+		}
+		str = value
+	default:
+		return "", false
+	}
+
+	if isTime("2006-01-02", str) {
+		return str, true
+	}
+	t, ok := toTime(str)
+	if !ok {
+		return "", false
+	}
+	return formatDateTime(t), true
+}
+
+// buildOperand renders an operand of a logical operator, grouping it when it
+// binds more loosely than the operator itself
+func (b *queryBuilder) buildOperand(expr Expression, parentPrecedence int) (string, error) {
+
+	str, err := b.build(expr)
+	if err != nil {
+		return "", err
+	}
+
+	if precedence(expr) < parentPrecedence {
+		return "(" + str + ")", nil
+	}
+	return str, nil
+}
+
+// buildIn renders 'a in (1, 2)' as '(a eq 1 or a eq 2)' and 'a not in (1, 2)'
+// as '(a ne 1 and a ne 2)'
+func (b *queryBuilder) buildIn(e *InExpr) (string, error) {
+
+	operand, err := b.build(e.Expr)
+	if err != nil {
+		return "", err
+	}
+
+	op, logicOp := "eq", kwOr
+	if e.Not {
+		op, logicOp = "ne", kwAnd
+	}
+
+	parts := make([]string, len(e.Values))
+	for i, value := range e.Values {
+		valueStr, err := b.build(value)
+		if err != nil {
+			return "", err
+		}
+		parts[i] = fmt.Sprintf("%s %s %s", operand, op, valueStr)
+	}
+
+	if len(parts) == 1 {
+		return parts[0], nil
+	}
+	return "(" + strings.Join(parts, " "+logicOp+" ") + ")", nil
+}
+
+// buildBetween renders 'a between 1 and 2' as '(a ge 1 and a le 2)' and
+// 'a not between 1 and 2' as '(a lt 1 or a gt 2)'
+func (b *queryBuilder) buildBetween(e *BetweenExpr) (string, error) {
+
+	operand, err := b.build(e.Expr)
+	if err != nil {
+		return "", err
+	}
+	low, err := b.build(e.Low)
+	if err != nil {
+		return "", err
+	}
+	high, err := b.build(e.High)
+	if err != nil {
+		return "", err
+	}
+
+	if e.Not {
+		return fmt.Sprintf("(%s lt %s or %s gt %s)", operand, low, operand, high), nil
+	}
+	return fmt.Sprintf("(%s ge %s and %s le %s)", operand, low, operand, high), nil
+}
+
+// buildLike maps the patterns 'abc%', '%abc' and '%abc%' to startswith,
+// endswith and contains, other uses of wildcards cannot be expressed in OData
+func (b *queryBuilder) buildLike(e *LikeExpr) (string, error) {
+
+	operand, err := b.build(e.Expr)
+	if err != nil {
+		return "", err
+	}
+
+	var pattern string
+	switch p := e.Pattern.(type) {
+	case *Literal:
+		if p.Kind != LiteralString {
+			return "", fmt.Errorf("invalid query: like requires a string pattern")
+		}
+		pattern = p.Value
+	case *Parameter:
+		value, err := b.param(p.Name)
+		if err != nil {
+			return "", err
+		}
+		var ok bool
+		pattern, ok = value.(string)
+		if !ok {
+			return "", fmt.Errorf("invalid query: like requires a string pattern, input param '%s' is %T", p.Name, value)
+		}
+	default:
+		return "", fmt.Errorf("invalid query: like requires a string pattern")
+	}
+
+	if problem := likePatternProblem(pattern); problem != "" {
+		return "", fmt.Errorf("invalid query: %s", problem)
+	}
+
+	var filter string
+	leading := strings.HasPrefix(pattern, "%")
+	trailing := len(pattern) > 1 && strings.HasSuffix(pattern, "%")
+	text := strings.TrimSuffix(strings.TrimPrefix(pattern, "%"), "%")
+
+	switch {
+	case pattern == "%":
+		if e.Not {
+			return operand + " eq null", nil
+		}
+		return operand + " ne null", nil
+	case leading && trailing:
+		filter = fmt.Sprintf("contains(%s, %s)", operand, quoteString(text))
+	case leading:
+		filter = fmt.Sprintf("endswith(%s, %s)", operand, quoteString(text))
+	case trailing:
+		filter = fmt.Sprintf("startswith(%s, %s)", operand, quoteString(text))
+	default:
+		if e.Not {
+			return fmt.Sprintf("%s ne %s", operand, quoteString(text)), nil
+		}
+		return fmt.Sprintf("%s eq %s", operand, quoteString(text)), nil
+	}
+
+	if e.Not {
+		return "not " + filter, nil
+	}
+	return filter, nil
+}
+
+// likePatternProblem describes why a like pattern cannot be expressed in
+// OData, it is empty for the patterns buildLike supports
+func likePatternProblem(pattern string) string {
+
+	text := strings.TrimSuffix(strings.TrimPrefix(pattern, "%"), "%")
+	if strings.Contains(text, "_") {
+		return fmt.Sprintf("unsupported like pattern %s, the _ wildcard has no equivalent in OData", quoteString(pattern))
+	}
+	if strings.Contains(text, "%") {
+		return fmt.Sprintf("unsupported like pattern %s, wildcards are only supported at the start or end", quoteString(pattern))
+	}
+	return ""
+}
+
+// formatValue renders a parameter value as a typed OData literal
+func formatValue(name string, value interface{}) (string, error) {
+
+	switch v := value.(type) {
+	case nil:
+		return kwNull, nil
+	case string:
+		return quoteString(v), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case json.Number:
+		if _, err := v.Float64(); err != nil {
+			return "", fmt.Errorf("invalid query: input param '%s' is not a valid number '%s'", name, v)
+		}
+		return v.String(), nil
+	case time.Time:
+		return formatDateTime(v), nil
+	case *time.Time:
+		if v == nil {
+			return kwNull, nil
+		}
+		return formatDateTime(*v), nil
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.String:
+		return quoteString(rv.String()), nil
+	case reflect.Bool:
+		return strconv.FormatBool(rv.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(rv.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		f := rv.Float()
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return "", fmt.Errorf("invalid query: input param '%s' is not a finite number", name)
+		}
+		return strconv.FormatFloat(f, 'f', -1, rv.Type().Bits()), nil
+	case reflect.Array:
+		if rv.Len() == 16 && rv.Type().Elem().Kind() == reflect.Uint8 {
+			return formatGuid(rv), nil
+		}
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			return kwNull, nil
+		}
+		return formatValue(name, rv.Elem().Interface())
+	}
+
+	return "", fmt.Errorf("invalid query: input param '%s' has unsupported type %T", name, value)
+}
+
+// formatDateTime renders a time as an OData 4 DateTimeOffset literal
+func formatDateTime(t time.Time) string {
+	return t.Format(time.RFC3339Nano)
+}
+
+var guidRegexp = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// typedLiteralFormats describes the values accepted for each typed literal
+var typedLiteralFormats = map[LiteralKind]string{
+	LiteralDate:      "yyyy-mm-dd",
+	LiteralTimestamp: "yyyy-mm-ddThh:mm:ss with an offset such as Z or +01:00",
+	LiteralGuid:      "xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx",
+}
+
+// isTypedValue reports whether a string is a valid value of a typed literal,
+// timestamps must have an offset as OData has no date time without one
+func isTypedValue(kind LiteralKind, value string) bool {
+
+	switch kind {
+	case LiteralDate:
+		return isTime("2006-01-02", value)
+	case LiteralTimestamp:
+		return isTime(time.RFC3339Nano, value)
+	case LiteralGuid:
+		return guidRegexp.MatchString(value)
+	}
+	return true
+}
+
+func isTime(layout string, value string) bool {
+	_, err := time.Parse(layout, value)
+	return err == nil
+}
+
+// formatTypedValue renders the value of a typed parameter, e.g. 'date :d',
+// which is either a string in the format of the literal or a time for dates
+// and timestamps and a 16 byte array for GUIDs
+func formatTypedValue(name string, kind LiteralKind, value interface{}) (string, error) {
+
+	rv := reflect.ValueOf(value)
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return kwNull, nil
+		}
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() {
+		return kwNull, nil
+	}
+
+	switch v := rv.Interface().(type) {
+	case time.Time:
+		switch kind {
+		case LiteralDate:
+			return v.Format("2006-01-02"), nil
+		case LiteralTimestamp:
+			return formatDateTime(v), nil
+		}
+	case string:
+		if isTypedValue(kind, v) {
+			return v, nil
+		}
+		return "", fmt.Errorf("invalid query: input param '%s' is not a valid value '%s', expected %s", name, v, typedLiteralFormats[kind])
+	}
+
+	if kind == LiteralGuid && rv.Kind() == reflect.Array && rv.Len() == 16 && rv.Type().Elem().Kind() == reflect.Uint8 {
+		return formatGuid(rv), nil
+	}
+
+	return "", fmt.Errorf("invalid query: input param '%s' has unsupported type %T, expected %s", name, value, typedLiteralFormats[kind])
+}
+
+// formatGuid renders a 16 byte GUID, e.g. a uuid.UUID, as a guid literal
+func formatGuid(rv reflect.Value) string {
+
+	b := make([]byte, 16)
+	for i := range b {
+		b[i] = byte(rv.Index(i).Uint())
+	}
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+func quoteString(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
@@ -0,0 +1,96 @@
+package sqlodata
+
+import (
+	"fmt"
+	"strings"
+)
+
+// QueryError is returned for a query that cannot be parsed. Line and Column
+// locate the offending token, both starting at 1 and 0 when the problem has no
+// single location, Token is the text of the token as written in the query,
+// empty at the end of the query, and Expected lists what would have been
+// accepted instead when it is known
+type QueryError struct {
+	Query    string
+	Message  string
+	Line     int
+	Column   int
+	Token    string
+	Expected []string
+}
+
+// newQueryError locates the character at position pos, counted in runes, in the query
+func newQueryError(query string, pos int, tokenText string, expected []string, message string) *QueryError {
+
+	e := &QueryError{
+		Query:    query,
+		Message:  message,
+		Token:    tokenText,
+		Expected: expected,
+	}
+
+	if pos < 0 {
+		return e
+	}
+
+	e.Line, e.Column = 1, 1
+	for i, r := range []rune(query) {
+		if i >= pos {
+			break
+		}
+		if r == '\n' {
+			e.Line++
+			e.Column = 1
+		} else {
+			e.Column++
+		}
+	}
+
+	return e
+}
+
+// Error renders the message followed by the line of the query in error and a
+// caret pointing at the offending token
+func (e *QueryError) Error() string {
+
+	var b strings.Builder
+	b.WriteString("invalid query: ")
+	b.WriteString(e.Message)
+
+	if e.Line == 0 {
+		return b.String()
+	}
+
+	fmt.Fprintf(&b, " at line %d, column %d", e.Line, e.Column)
+
+	lines := strings.Split(e.Query, "\n")
+	if e.Line > len(lines) {
+		return b.String()
+	}
+	line := []rune(strings.TrimRight(lines[e.Line-1], "\r"))
+
+	b.WriteString("\n")
+	b.WriteString(string(line))
+	b.WriteString("\n")
+	for i := 0; i < e.Column-1 && i < len(line); i++ {
+		// tabs are kept so the caret lines up with the query
+		if line[i] == '\t' {
+			b.WriteRune('\t')
+		} else {
+			b.WriteRune(' ')
+		}
+	}
+	b.WriteString("^")
+
+	return b.String()
+}
+
+// alternatives joins the expected alternatives for an error message, e.g.
+// "where, group by or order by"
+func alternatives(expected []string) string {
+
+	if len(expected) == 1 {
+		return expected[0]
+	}
+	return strings.Join(expected[:len(expected)-1], ", ") + " or " + expected[len(expected)-1]
+}
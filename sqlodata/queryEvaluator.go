@@ -0,0 +1,318 @@
+package sqlodata
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rowEvaluator evaluates conditions against result rows, it is used for the
+// parts of a query that are processed client side when the server cannot
+type rowEvaluator struct {
+	params  map[string]interface{}
+	aliases map[string]string
+}
+
+// test evaluates a condition for a row, null is treated as false
+func (e *rowEvaluator) test(expr Expression, row map[string]interface{}) (bool, error) {
+
+	value, err := e.eval(expr, row)
+	if err != nil {
+		return false, err
+	}
+
+	b, ok := value.(bool)
+	return ok && b, nil
+}
+
+func (e *rowEvaluator) eval(expr Expression, row map[string]interface{}) (interface{}, error) {
+
+	switch x := expr.(type) {
+	case *LogicalExpr:
+		left, err := e.test(x.Left, row)
+		if err != nil {
+			return nil, err
+		}
+		if x.Op == kwAnd && !left {
+			return false, nil
+		}
+		if x.Op == kwOr && left {
+			return true, nil
+		}
+		return e.test(x.Right, row)
+	case *NotExpr:
+		value, err := e.test(x.Expr, row)
+		if err != nil {
+			return nil, err
+		}
+		return !value, nil
+	case *ComparisonExpr:
+		left, err := e.eval(x.Left, row)
+		if err != nil {
+			return nil, err
+		}
+		right, err := e.eval(x.Right, row)
+		if err != nil {
+			return nil, err
+		}
+		return compareOp(opMap[x.Op], left, right), nil
+	case *InExpr:
+		value, err := e.eval(x.Expr, row)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range x.Values {
+			itemValue, err := e.eval(item, row)
+			if err != nil {
+				return nil, err
+			}
+			if compareOp("eq", value, itemValue) {
+				return !x.Not, nil
+			}
+		}
+		return x.Not, nil
+	case *BetweenExpr:
+		value, err := e.eval(x.Expr, row)
+		if err != nil {
+			return nil, err
+		}
+		low, err := e.eval(x.Low, row)
+		if err != nil {
+			return nil, err
+		}
+		high, err := e.eval(x.High, row)
+		if err != nil {
+			return nil, err
+		}
+		between := compareOp("ge", value, low) && compareOp("le", value, high)
+		return between != x.Not, nil
+	case *LikeExpr:
+		value, err := e.eval(x.Expr, row)
+		if err != nil {
+			return nil, err
+		}
+		pattern, err := e.eval(x.Pattern, row)
+		if err != nil {
+			return nil, err
+		}
+		str, ok := value.(string)
+		patternStr, patternOk := pattern.(string)
+		if !ok || !patternOk {
+			return false, nil
+		}
+		return likeRegexp(patternStr).MatchString(str) != x.Not, nil
+	case *IsNullExpr:
+		value, err := e.eval(x.Expr, row)
+		if err != nil {
+			return nil, err
+		}
+		return (value == nil) != x.Not, nil
+	case *ColumnRef:
+		value, _ := lookupField(row, x.Name)
+		return value, nil
+	case *AggregateExpr:
+		alias, ok := e.aliases[x.key()]
+		if !ok {
+			return nil, fmt.Errorf("invalid query: aggregates are only supported in select and having")
+		}
+		value, _ := lookupField(row, alias)
+		return value, nil
+	case *FunctionCall:
+		args := make([]interface{}, len(x.Args))
+		for i, arg := range x.Args {
+			value, err := e.eval(arg, row)
+			if err != nil {
+				return nil, err
+			}
+			args[i] = value
+		}
+		return evalFunction(x.fn, args), nil
+	case *Literal:
+		switch x.Kind {
+		case LiteralString:
+			return x.Value, nil
+		case LiteralNumber:
+			return strconv.ParseFloat(x.Value, 64)
+		case LiteralBool:
+			return x.Value == kwTrue, nil
+		case LiteralDate, LiteralTimestamp:
+			t, _ := toTime(x.Value)
+			return t, nil
+		case LiteralGuid:
+			return x.Value, nil
+		}
+		return nil, nil
+	case *Parameter:
+		value, ok := e.params[x.Name]
+		if !ok {
+			return nil, fmt.Errorf("invalid query: input param '%s' is referenced by the query but was not provided", x.Name)
+		}
+		if x.Kind != LiteralString && value != nil {
+			str, err := formatTypedValue(x.Name, x.Kind, value)
+			if err != nil {
+				return nil, err
+			}
+			return e.eval(&Literal{Kind: x.Kind, Value: str}, row)
+		}
+		if f, ok := toFloat(value); ok {
+			return f, nil
+		}
+		return value, nil
+	}
+
+	return nil, fmt.Errorf("invalid query: unsupported expression")
+}
+
+// lookupField finds a field of a row, falling back to a case insensitive
+// match since the server matches column names regardless of case
+func lookupField(row map[string]interface{}, name string) (interface{}, bool) {
+
+	key, ok := findKey(row, name)
+	if !ok {
+		return nil, false
+	}
+	return row[key], true
+}
+
+// compareOp applies an OData comparison operator, null is only equal to null
+// and is neither less nor greater than any value
+func compareOp(op string, left interface{}, right interface{}) bool {
+
+	if left == nil || right == nil {
+		switch op {
+		case "eq":
+			return left == nil && right == nil
+		case "ne":
+			return (left == nil) != (right == nil)
+		}
+		return false
+	}
+
+	c, ok := compareValues(left, right)
+	if !ok {
+		return op == "ne"
+	}
+
+	switch op {
+	case "eq":
+		return c == 0
+	case "ne":
+		return c != 0
+	case "gt":
+		return c > 0
+	case "ge":
+		return c >= 0
+	case "lt":
+		return c < 0
+	case "le":
+		return c <= 0
+	}
+	return false
+}
+
+// compareValues orders two non null values of the same kind, ok is false if
+// the values cannot be compared
+func compareValues(left interface{}, right interface{}) (int, bool) {
+
+	_, leftTime := left.(time.Time)
+	_, rightTime := right.(time.Time)
+	if leftTime || rightTime {
+		l, ok := toTime(left)
+		if !ok {
+			return 0, false
+		}
+		r, ok := toTime(right)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case l.Before(r):
+			return -1, true
+		case l.After(r):
+			return 1, true
+		}
+		return 0, true
+	}
+
+	if l, ok := toFloat(left); ok {
+		r, ok := toFloat(right)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case l < r:
+			return -1, true
+		case l > r:
+			return 1, true
+		}
+		return 0, true
+	}
+
+	switch l := left.(type) {
+	case string:
+		r, ok := right.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(l, r), true
+	case bool:
+		r, ok := right.(bool)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case l == r:
+			return 0, true
+		case !l:
+			return -1, true
+		}
+		return 1, true
+	}
+
+	return 0, false
+}
+
+func toFloat(value interface{}) (float64, bool) {
+
+	if n, ok := value.(json.Number); ok {
+		f, err := n.Float64()
+		return f, err == nil
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	}
+
+	return 0, false
+}
+
+// likeRegexp converts a like pattern to a regular expression, '%' matches any
+// sequence of characters and '_' any single character
+func likeRegexp(pattern string) *regexp.Regexp {
+
+	var expr strings.Builder
+	expr.WriteString("(?s)^")
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			expr.WriteString(".*")
+		case '_':
+			expr.WriteString(".")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	expr.WriteString("$")
+
+	return regexp.MustCompile(expr.String())
+}
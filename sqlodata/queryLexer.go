@@ -0,0 +1,412 @@
+package sqlodata
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+type tokenType int
+
+const (
+	tokenEOF tokenType = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenParam
+	tokenOperator
+	tokenComma
+	tokenDot
+	tokenStar
+	tokenLParen
+	tokenRParen
+	tokenTyped
+)
+
+func (t tokenType) String() string {
+	switch t {
+	case tokenEOF:
+		return "end of query"
+	case tokenIdent:
+		return "identifier"
+	case tokenString:
+		return "string"
+	case tokenNumber:
+		return "number"
+	case tokenParam:
+		return "parameter"
+	case tokenOperator:
+		return "operator"
+	case tokenComma:
+		return "','"
+	case tokenDot:
+		return "'.'"
+	case tokenStar:
+		return "'*'"
+	case tokenLParen:
+		return "'('"
+	case tokenRParen:
+		return "')'"
+	case tokenTyped:
+		return "typed literal"
+	}
+	return "unknown"
+}
+
+// token is a single lexical element of a query. text holds the raw text as
+// written in the query while value holds the decoded value, e.g. the contents
+// of a string literal without quotes or the name of a parameter without ':'.
+// quoted is set for identifiers written as "name", [name] or `name`, kind is
+// the kind of a typed literal.
+type token struct {
+	typ    tokenType
+	text   string
+	value  string
+	pos    int
+	quoted bool
+	kind   LiteralKind
+}
+
+func (t token) String() string {
+	switch t.typ {
+	case tokenEOF:
+		return t.typ.String()
+	case tokenString:
+		// the text of a string is already quoted
+		return t.text
+	}
+	return fmt.Sprintf("'%s'", t.text)
+}
+
+var operators = []string{"==", "!=", "<>", ">=", "<=", "!<", "!>", "=", ">", "<", "-"}
+
+// odataLiterals matches the OData literals that are written without quotes
+// and have a typed literal equivalent in queries
+var odataLiterals = []struct {
+	kind LiteralKind
+	re   *regexp.Regexp
+}{
+	{LiteralTimestamp, regexp.MustCompile(`^[0-9]{4}-[0-9]{2}-[0-9]{2}T[0-9]{2}:[0-9]{2}:[0-9]{2}(\.[0-9]+)?(Z|[+-][0-9]{2}:[0-9]{2})`)},
+	{LiteralGuid, regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`)},
+	{LiteralDate, regexp.MustCompile(`^[0-9]{4}-[0-9]{2}-[0-9]{2}`)},
+}
+
+type queryLexer struct {
+	runes  []rune
+	pos    int
+	tokens []token
+	odata  bool
+}
+
+func lexQuery(query string) ([]token, error) {
+	return (&queryLexer{runes: []rune(query)}).lex()
+}
+
+// lexFilter splits an OData $filter into tokens, unlike queries it has dates,
+// timestamps and GUIDs written without quotes and $it paths
+func lexFilter(filter string) ([]token, error) {
+	return (&queryLexer{runes: []rune(filter), odata: true}).lex()
+}
+
+func (l *queryLexer) lex() ([]token, error) {
+
+	for {
+		err := l.skipWhitespace()
+		if err != nil {
+			return nil, err
+		}
+		if l.pos >= len(l.runes) {
+			break
+		}
+
+		err = l.lexToken()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	l.tokens = append(l.tokens, token{typ: tokenEOF, pos: l.pos})
+
+	return l.tokens, nil
+}
+
+// errorAt returns a QueryError pointing at the text starting at position start
+func (l *queryLexer) errorAt(start int, expected []string, format string, args ...interface{}) error {
+
+	end := start + 1
+	for end < len(l.runes) && !unicode.IsSpace(l.runes[end]) {
+		end++
+	}
+
+	return newQueryError(string(l.runes), start, string(l.runes[start:end]), expected, fmt.Sprintf(format, args...))
+}
+
+// skipWhitespace skips whitespace, including line breaks and tabs, and the
+// '-- line' and '/* block */' comments
+func (l *queryLexer) skipWhitespace() error {
+
+	for l.pos < len(l.runes) {
+		switch {
+		case unicode.IsSpace(l.runes[l.pos]):
+			l.pos++
+		case l.hasPrefix("--"):
+			for l.pos < len(l.runes) && l.runes[l.pos] != '\n' {
+				l.pos++
+			}
+		case l.hasPrefix("/*"):
+			start := l.pos
+			l.pos += 2
+			for !l.hasPrefix("*/") {
+				if l.pos >= len(l.runes) {
+					return l.errorAt(start, []string{"*/"}, "unterminated comment")
+				}
+				l.pos++
+			}
+			l.pos += 2
+		default:
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func (l *queryLexer) emit(typ tokenType, start int, value string) {
+	l.tokens = append(l.tokens, token{
+		typ:   typ,
+		text:  string(l.runes[start:l.pos]),
+		value: value,
+		pos:   start,
+	})
+}
+
+func (l *queryLexer) lexToken() error {
+
+	start := l.pos
+	r := l.runes[l.pos]
+
+	if l.odata && unicode.Is(unicode.ASCII_Hex_Digit, r) && l.lexTypedLiteral() {
+		return nil
+	}
+
+	switch {
+	case r == '\'':
+		return l.lexString()
+	case r == '"':
+		return l.lexQuotedIdent('"')
+	case r == '`':
+		return l.lexQuotedIdent('`')
+	case r == '[':
+		return l.lexQuotedIdent(']')
+	case unicode.IsDigit(r):
+		return l.lexNumber()
+	case r == '.' && l.pos+1 < len(l.runes) && unicode.IsDigit(l.runes[l.pos+1]):
+		return l.lexNumber()
+	case isIdentStart(r):
+		l.lexIdent()
+		return nil
+	case r == ':':
+		l.pos++
+		for l.pos < len(l.runes) && isIdentPart(l.runes[l.pos]) {
+			l.pos++
+		}
+		if l.pos == start+1 {
+			return l.errorAt(start, []string{"parameter name"}, "parameter name expected after ':'")
+		}
+		l.emit(tokenParam, start, string(l.runes[start+1:l.pos]))
+		return nil
+	case l.odata && l.hasPrefix("$it/"):
+		// $it/name is a column of the outer entity in the filter of an
+		// expanded entity
+		l.pos += 3
+		l.emit(tokenIdent, start, "$it")
+		return nil
+	case l.odata && r == '/':
+		l.pos++
+		l.emit(tokenOperator, start, "/")
+		return nil
+	case r == ',':
+		l.pos++
+		l.emit(tokenComma, start, ",")
+		return nil
+	case r == '.':
+		l.pos++
+		l.emit(tokenDot, start, ".")
+		return nil
+	case r == '*':
+		l.pos++
+		l.emit(tokenStar, start, "*")
+		return nil
+	case r == '(':
+		l.pos++
+		l.emit(tokenLParen, start, "(")
+		return nil
+	case r == ')':
+		l.pos++
+		l.emit(tokenRParen, start, ")")
+		return nil
+	}
+
+	for _, op := range operators {
+		if l.hasPrefix(op) {
+			l.pos += len(op)
+			l.emit(tokenOperator, start, op)
+			return nil
+		}
+	}
+
+	return l.errorAt(start, nil, "unexpected character '%c'", r)
+}
+
+// lexTypedLiteral reads an OData date, timestamp or GUID, it reports false
+// if there is none at the current position
+func (l *queryLexer) lexTypedLiteral() bool {
+
+	rest := string(l.runes[l.pos:])
+	for _, literal := range odataLiterals {
+		text := literal.re.FindString(rest)
+		end := l.pos + len([]rune(text))
+		if text == "" || !isTypedValue(literal.kind, text) || end < len(l.runes) && isIdentPart(l.runes[end]) {
+			continue
+		}
+		start := l.pos
+		l.pos = end
+		l.emit(tokenTyped, start, text)
+		l.tokens[len(l.tokens)-1].kind = literal.kind
+		return true
+	}
+
+	return false
+}
+
+func (l *queryLexer) hasPrefix(s string) bool {
+
+	i := l.pos
+	for _, r := range s {
+		if i >= len(l.runes) || l.runes[i] != r {
+			return false
+		}
+		i++
+	}
+
+	return true
+}
+
+// lexString reads a single quoted string literal, a quote inside the literal
+// is escaped by doubling it
+func (l *queryLexer) lexString() error {
+
+	start := l.pos
+	l.pos++
+
+	var value strings.Builder
+	for l.pos < len(l.runes) {
+		r := l.runes[l.pos]
+		l.pos++
+		if r == '\'' {
+			if l.pos < len(l.runes) && l.runes[l.pos] == '\'' {
+				value.WriteRune('\'')
+				l.pos++
+				continue
+			}
+			l.emit(tokenString, start, value.String())
+			return nil
+		}
+		value.WriteRune(r)
+	}
+
+	return l.errorAt(start, []string{"'"}, "unterminated string")
+}
+
+// lexQuotedIdent reads an identifier enclosed in double quotes, brackets or
+// backticks, the closing character is escaped by doubling it
+func (l *queryLexer) lexQuotedIdent(closing rune) error {
+
+	start := l.pos
+	l.pos++
+
+	var value strings.Builder
+	for l.pos < len(l.runes) {
+		r := l.runes[l.pos]
+		l.pos++
+		if r == closing {
+			if l.pos < len(l.runes) && l.runes[l.pos] == closing {
+				value.WriteRune(closing)
+				l.pos++
+				continue
+			}
+			if value.Len() == 0 {
+				return l.errorAt(start, []string{"identifier"}, "empty identifier")
+			}
+			l.emit(tokenIdent, start, value.String())
+			l.tokens[len(l.tokens)-1].quoted = true
+			return nil
+		}
+		value.WriteRune(r)
+	}
+
+	return l.errorAt(start, []string{string(closing)}, "unterminated identifier")
+}
+
+// lexNumber reads a number, OData requires digits on both sides of the
+// decimal point so a number starting with '.' is given a leading 0 and one
+// ending with '.' is an error
+func (l *queryLexer) lexNumber() error {
+
+	start := l.pos
+	seenDot := false
+	for l.pos < len(l.runes) {
+		r := l.runes[l.pos]
+		if unicode.IsDigit(r) {
+			l.pos++
+		} else if r == '.' && !seenDot {
+			if l.pos+1 >= len(l.runes) || !unicode.IsDigit(l.runes[l.pos+1]) {
+				return l.errorAt(start, []string{"digit"}, "invalid number '%s', digits expected after '.'", string(l.runes[start:l.pos+1]))
+			}
+			seenDot = true
+			l.pos++
+		} else if (r == 'e' || r == 'E') && l.pos+1 < len(l.runes) {
+			next := l.runes[l.pos+1]
+			if unicode.IsDigit(next) {
+				l.pos++
+			} else if (next == '+' || next == '-') && l.pos+2 < len(l.runes) && unicode.IsDigit(l.runes[l.pos+2]) {
+				l.pos += 2
+			} else {
+				break
+			}
+			for l.pos < len(l.runes) && unicode.IsDigit(l.runes[l.pos]) {
+				l.pos++
+			}
+			break
+		} else {
+			break
+		}
+	}
+
+	value := string(l.runes[start:l.pos])
+	if strings.HasPrefix(value, ".") {
+		value = "0" + value
+	}
+	l.emit(tokenNumber, start, value)
+
+	return nil
+}
+
+func (l *queryLexer) lexIdent() {
+
+	start := l.pos
+	for l.pos < len(l.runes) && isIdentPart(l.runes[l.pos]) {
+		l.pos++
+	}
+
+	l.emit(tokenIdent, start, string(l.runes[start:l.pos]))
+}
+
+func isIdentStart(r rune) bool {
+	return r == '_' || unicode.IsLetter(r)
+}
+
+func isIdentPart(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,1528 @@
+package sqlodata
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	kwSelect   = "select"
+	allColumns = "*"
+	kwTop      = "top"
+	kwSkip     = "skip"
+	kwFrom     = "from"
+	kwWhere    = "where"
+	kwOrderBy  = "orderby"
+	kwOrder    = "order"
+	kwBy       = "by"
+	kwLimit    = "limit"
+	kwOffset   = "offset"
+	kwFetch    = "fetch"
+	kwFirst    = "first"
+	kwNext     = "next"
+	kwRow      = "row"
+	kwRows     = "rows"
+	kwOnly     = "only"
+	kwGroup    = "group"
+	kwHaving   = "having"
+	kwAs       = "as"
+	kwDistinct = "distinct"
+	kwAsc      = "asc"
+	kwDesc     = "desc"
+	kwJoin     = "join"
+	kwLeft     = "left"
+	kwOuter    = "outer"
+	kwOn       = "on"
+	kwExpand   = "expand"
+)
+
+var opMap = map[string]string{
+	"=":  "eq",
+	"==": "eq",
+	"!=": "ne",
+	"<>": "ne",
+	">":  "gt",
+	">=": "ge",
+	"!<": "ge",
+	"<":  "lt",
+	"<=": "le",
+	"!>": "le",
+}
+
+const (
+	kwAnd = "and"
+	kwOr  = "or"
+	kwNot = "not"
+)
+
+const (
+	kwIn      = "in"
+	kwBetween = "between"
+	kwLike    = "like"
+	kwIs      = "is"
+)
+
+const (
+	kwCount = "count"
+	kwSum   = "sum"
+	kwAvg   = "avg"
+	kwMin   = "min"
+	kwMax   = "max"
+)
+
+// aggregateMap maps the aggregate functions to the OData aggregation methods
+var aggregateMap = map[string]string{
+	kwSum: "sum",
+	kwAvg: "average",
+	kwMin: "min",
+	kwMax: "max",
+}
+
+const (
+	kwTrue  = "true"
+	kwFalse = "false"
+	kwNull  = "null"
+)
+
+// typedLiteralKinds are the keywords of the typed literals and parameters,
+// e.g. date '2025-01-31' or guid :id
+var typedLiteralKinds = map[string]LiteralKind{
+	"date":      LiteralDate,
+	"timestamp": LiteralTimestamp,
+	"guid":      LiteralGuid,
+}
+
+// parseStatement turns the query text into a syntax tree, the grammar is
+//
+//	select [distinct] [top n] [skip n] * | item [, item ...]
+//	from table [[as] alias]
+//	[left [outer] join entity [[as] alias] on condition ...] | [expand entity [, entity ...]]
+//	[where condition]
+//	[group by column [, column ...]]
+//	[having condition]
+//	[orderby | order by column [asc | desc | :param] [, column ...]]
+//	[top n] [skip n] | [limit n] [offset n] | [offset n rows] [fetch first | next n rows only]
+//
+// the clauses must appear in this order and at most once, any token that is
+// not part of the grammar is an error. The paging values are numbers or
+// parameters and a select item is a
+// column, an aggregate or a function, optionally followed by [as] alias
+//
+//	column | count(*) | count([distinct] column) | sum | avg | min | max(column)
+//
+// columns may be qualified with the table name or alias, e.g. a.Name
+//
+// where a condition is built from predicates combined with not, and, or and
+// parentheses, with not binding tighter than and, and and tighter than or. A
+// predicate is one of
+//
+//	operand op operand
+//	operand [not] in (operand [, operand ...])
+//	operand [not] between operand and operand
+//	operand [not] like pattern
+//	operand is [not] null
+//
+// where an operand is a column, a literal, a parameter or a call to one of the
+// canonical functions, e.g. toupper(name). Functions can also be selected
+func parseStatement(queryString string) (*selectStatement, error) {
+
+	if strings.TrimSpace(queryString) == "" {
+		return nil, fmt.Errorf("'query' is required")
+	}
+
+	tokens, err := lexQuery(queryString)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &queryParser{query: queryString, tokens: tokens}
+
+	return p.parseSelect()
+}
+
+type queryParser struct {
+	query   string
+	tokens  []token
+	pos     int
+	clauses []string
+}
+
+// errorAt returns a QueryError pointing at the token t
+func (p *queryParser) errorAt(t token, expected []string, format string, args ...interface{}) error {
+	return newQueryError(p.query, t.pos, t.text, expected, fmt.Sprintf(format, args...))
+}
+
+// errorAtExpr returns a QueryError pointing at the first column, aggregate or
+// function of expr
+func (p *queryParser) errorAtExpr(expr Expression, format string, args ...interface{}) error {
+	pos, text := exprLocation(expr)
+	return newQueryError(p.query, pos, text, nil, fmt.Sprintf(format, args...))
+}
+
+func (p *queryParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *queryParser) next() token {
+	t := p.tokens[p.pos]
+	if t.typ != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *queryParser) peekAt(offset int) token {
+	if p.pos+offset >= len(p.tokens) {
+		return p.tokens[len(p.tokens)-1]
+	}
+	return p.tokens[p.pos+offset]
+}
+
+func (p *queryParser) isKeyword(keyword string) bool {
+	return isKeywordToken(p.peek(), keyword)
+}
+
+func isKeywordToken(t token, keyword string) bool {
+	return t.typ == tokenIdent && !t.quoted && strings.ToLower(t.text) == keyword
+}
+
+func (p *queryParser) acceptKeyword(keyword string) bool {
+	if p.isKeyword(keyword) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *queryParser) parseSelect() (*selectStatement, error) {
+
+	if !p.acceptKeyword(kwSelect) {
+		return nil, p.errorAt(p.peek(), []string{kwSelect}, "only select statements are supported")
+	}
+
+	stmt := &selectStatement{}
+	stmt.distinct = p.acceptKeyword(kwDistinct)
+
+	err := p.parsePaging(stmt, false)
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.parseSelectList(stmt)
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.acceptKeyword(kwFrom) {
+		return nil, p.errorAt(p.peek(), []string{kwFrom}, "a from clause is required, found %s", p.peek())
+	}
+
+	t := p.peek()
+	if !isName(t) {
+		return nil, p.errorAt(t, []string{"table name"}, "table name is required, found %s", t)
+	}
+	stmt.from = p.next().value
+	p.clauses = append(p.clauses, kwFrom)
+
+	if p.acceptKeyword(kwAs) || isName(p.peek()) {
+		alias := p.peek()
+		if !isName(alias) {
+			return nil, p.errorAt(alias, []string{"table alias"}, "table alias expected, found %s", alias)
+		}
+		stmt.fromAlias = p.next().value
+	}
+
+	stmt.joins, err = p.parseJoins()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.acceptKeyword(kwWhere) {
+		p.clauses = append(p.clauses, kwWhere)
+		stmt.where, err = p.parseWhere()
+		if err != nil {
+			return nil, err
+		}
+		if containsAggregate(stmt.where) {
+			return nil, p.errorAtExpr(firstAggregate(stmt.where), "aggregates are not allowed in where, use having instead")
+		}
+	}
+
+	if p.isKeyword(kwGroup) {
+		if !isKeywordToken(p.peekAt(1), kwBy) {
+			return nil, p.errorAt(p.peekAt(1), []string{kwBy}, "by expected after group, found %s", p.peekAt(1))
+		}
+		p.next()
+		p.next()
+		p.clauses = append(p.clauses, groupByClause)
+
+		stmt.groupBy, err = p.parseGroupBy()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if p.acceptKeyword(kwHaving) {
+		p.clauses = append(p.clauses, kwHaving)
+		if t := p.peek(); t.typ == tokenEOF || (isReservedToken(t) && !p.isKeyword(kwNot)) {
+			return nil, p.errorAt(t, []string{"condition"}, "empty having clause")
+		}
+		stmt.having, err = p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if p.acceptOrderBy() {
+		p.clauses = append(p.clauses, orderByClause)
+		stmt.orderBy, err = p.parseOrderBy()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = p.parsePaging(stmt, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if t := p.peek(); t.typ != tokenEOF {
+		return nil, p.unexpected(t)
+	}
+
+	err = p.resolveColumns(stmt)
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.validateNames(stmt)
+	if err != nil {
+		return nil, err
+	}
+
+	if stmt.hasFunctions() {
+		err = p.validateFunctions(stmt)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(stmt.joins) > 0 {
+		err = p.validateJoins(stmt)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if stmt.isAggregate() {
+		err = p.validateAggregate(stmt)
+		if err != nil {
+			return nil, err
+		}
+	} else if stmt.distinct && !stmt.all {
+		err = p.validateDistinct(stmt)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return stmt, nil
+}
+
+// parseJoins reads the related entities to read with each row, either as joins
+// or as an expand clause listing the entities
+func (p *queryParser) parseJoins() ([]*join, error) {
+
+	var joins []*join
+
+	if p.acceptKeyword(kwExpand) {
+		p.clauses = append(p.clauses, kwExpand)
+		for {
+			t := p.peek()
+			if !isName(t) {
+				return nil, p.errorAt(t, []string{"related entity name"}, "related entity name expected, found %s", t)
+			}
+			joins = append(joins, &join{name: t.value, pos: p.next().pos})
+
+			if p.peek().typ != tokenComma {
+				return joins, nil
+			}
+			p.next()
+		}
+	}
+
+	for {
+		left := p.acceptKeyword(kwLeft)
+		if left {
+			p.acceptKeyword(kwOuter)
+			if !p.isKeyword(kwJoin) {
+				return nil, p.errorAt(p.peek(), []string{kwJoin}, "join expected, found %s", p.peek())
+			}
+		}
+		if !p.isKeyword(kwJoin) {
+			return joins, nil
+		}
+		// the related rows are nested in each row, so rows without related
+		// rows are always returned
+		if !left {
+			return nil, p.errorAt(p.peek(), []string{kwLeft + " " + kwJoin, kwExpand}, "inner joins are not supported, rows without related rows are returned, use left join or expand")
+		}
+		p.next()
+		if len(joins) == 0 {
+			p.clauses = append(p.clauses, kwJoin)
+		}
+
+		t := p.peek()
+		if !isName(t) {
+			return nil, p.errorAt(t, []string{"related entity name"}, "related entity name expected after join, found %s", t)
+		}
+		j := &join{name: t.value, pos: p.next().pos}
+
+		if p.acceptKeyword(kwAs) || isName(p.peek()) {
+			alias := p.peek()
+			if !isName(alias) {
+				return nil, p.errorAt(alias, []string{"table alias"}, "table alias expected, found %s", alias)
+			}
+			j.alias = p.next().value
+		}
+
+		if !p.acceptKeyword(kwOn) {
+			return nil, p.errorAt(p.peek(), []string{kwOn}, "on expected after join %s, found %s", j.name, p.peek())
+		}
+		if t := p.peek(); t.typ == tokenEOF || (isReservedToken(t) && !p.isKeyword(kwNot)) {
+			return nil, p.errorAt(t, []string{"condition"}, "empty on condition for join %s", j.name)
+		}
+
+		var err error
+		j.on, err = p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if containsAggregate(j.on) {
+			return nil, p.errorAtExpr(firstAggregate(j.on), "aggregates are not allowed in on")
+		}
+
+		joins = append(joins, j)
+	}
+}
+
+// the names of the clauses made of two keywords
+const (
+	groupByClause = kwGroup + " " + kwBy
+	orderByClause = kwOrder + " " + kwBy
+)
+
+// clauses lists the clauses following the select list in the order they must
+// appear, the paging clauses can appear in any order
+var clauses = []string{kwFrom, kwJoin, kwExpand, kwWhere, groupByClause, kwHaving, orderByClause, kwTop, kwSkip, kwLimit, kwOffset, kwFetch}
+
+func clauseRank(clause string) int {
+
+	switch clause {
+	case kwExpand:
+		return clauseRank(kwJoin)
+	case kwSkip, kwLimit, kwOffset, kwFetch:
+		return clauseRank(kwTop)
+	}
+
+	for i, c := range clauses {
+		if c == clause {
+			return i
+		}
+	}
+	return -1
+}
+
+// clauseOf returns the clause a token starts, or an empty string
+func clauseOf(t token) string {
+
+	if t.typ != tokenIdent || t.quoted {
+		return ""
+	}
+
+	switch keyword := strings.ToLower(t.text); keyword {
+	case kwGroup:
+		return groupByClause
+	case kwOrder, kwOrderBy:
+		return orderByClause
+	case kwLeft:
+		return kwJoin
+	case kwSelect, kwFrom, kwJoin, kwExpand, kwWhere, kwHaving, kwTop, kwSkip, kwLimit, kwOffset, kwFetch:
+		return keyword
+	}
+	return ""
+}
+
+// unexpected reports a token left over once the statement is parsed, naming
+// the clauses that are repeated or out of order
+func (p *queryParser) unexpected(t token) error {
+
+	clause := clauseOf(t)
+	if clause == kwSelect {
+		return p.errorAt(t, []string{"end of query"}, "only one select statement is supported")
+	}
+
+	if clause != "" {
+		for _, seen := range p.clauses {
+			if seen == clause || (clause == kwJoin && seen == kwExpand) || (clause == kwExpand && seen == kwJoin) {
+				return p.errorAt(t, nil, "duplicate %s clause", clause)
+			}
+		}
+		for _, seen := range p.clauses {
+			if clauseRank(seen) > clauseRank(clause) {
+				return p.errorAt(t, nil, "%s clause must come before %s", clause, seen)
+			}
+		}
+	}
+
+	var expected []string
+	last := p.clauses[len(p.clauses)-1]
+	switch last {
+	case kwJoin, kwWhere, kwHaving:
+		expected = append(expected, kwAnd, kwOr)
+	}
+	for _, c := range clauses {
+		if clauseRank(c) > clauseRank(last) {
+			expected = append(expected, c)
+		}
+	}
+	expected = append(expected, "end of query")
+
+	return p.errorAt(t, expected, "unexpected %s, expected %s", t, alternatives(expected))
+}
+
+// parsePaging reads the clauses that limit the rows returned in any order,
+// top and skip are accepted either right after select or at the end of the
+// query while the standard limit, offset and fetch only at the end
+func (p *queryParser) parsePaging(stmt *selectStatement, trailing bool) error {
+
+	for {
+		var err error
+		keyword := strings.ToLower(p.peek().text)
+		switch {
+		case p.acceptKeyword(kwTop):
+			err = p.parseTop(stmt, kwTop)
+		case p.acceptKeyword(kwSkip):
+			err = p.parseSkip(stmt, kwSkip)
+		case trailing && p.acceptKeyword(kwLimit):
+			err = p.parseTop(stmt, kwLimit)
+		case trailing && p.acceptKeyword(kwOffset):
+			err = p.parseSkip(stmt, kwOffset)
+			if err == nil && !p.acceptKeyword(kwRows) {
+				p.acceptKeyword(kwRow)
+			}
+		case trailing && p.acceptKeyword(kwFetch):
+			err = p.parseFetch(stmt)
+		default:
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if trailing {
+			p.clauses = append(p.clauses, keyword)
+		}
+	}
+}
+
+func (p *queryParser) parseTop(stmt *selectStatement, keyword string) error {
+
+	if stmt.top != nil {
+		return p.errorAt(p.tokens[p.pos-1], nil, "%s specified but the number of rows is already limited", keyword)
+	}
+
+	var err error
+	stmt.top, err = p.parseCount(keyword)
+	return err
+}
+
+func (p *queryParser) parseSkip(stmt *selectStatement, keyword string) error {
+
+	if stmt.skip != nil {
+		return p.errorAt(p.tokens[p.pos-1], nil, "%s specified but the rows to skip are already specified", keyword)
+	}
+
+	var err error
+	stmt.skip, err = p.parseCount(keyword)
+	return err
+}
+
+// parseFetch reads 'fetch first | next [n] row | rows only', the count
+// defaults to one row when omitted
+func (p *queryParser) parseFetch(stmt *selectStatement) error {
+
+	if !p.acceptKeyword(kwFirst) && !p.acceptKeyword(kwNext) {
+		return p.errorAt(p.peek(), []string{kwFirst, kwNext}, "first or next expected after fetch, found %s", p.peek())
+	}
+
+	if stmt.top != nil {
+		return p.errorAt(p.tokens[p.pos-2], nil, "%s specified but the number of rows is already limited", kwFetch)
+	}
+
+	if p.isKeyword(kwRow) || p.isKeyword(kwRows) {
+		stmt.top = &Literal{Kind: LiteralNumber, Value: "1"}
+	} else {
+		var err error
+		stmt.top, err = p.parseCount(kwFetch)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !p.acceptKeyword(kwRows) && !p.acceptKeyword(kwRow) {
+		return p.errorAt(p.peek(), []string{kwRow, kwRows}, "rows expected in fetch, found %s", p.peek())
+	}
+
+	if !p.acceptKeyword(kwOnly) {
+		return p.errorAt(p.peek(), []string{kwOnly}, "only expected in fetch, found %s", p.peek())
+	}
+
+	return nil
+}
+
+func (p *queryParser) parseCount(keyword string) (Expression, error) {
+
+	t := p.peek()
+	if t.typ == tokenEOF || isReservedToken(t) {
+		return nil, p.errorAt(t, []string{"number", "parameter"}, "value not found for %s", keyword)
+	}
+	p.next()
+
+	if t.typ == tokenParam {
+		return &Parameter{Name: t.value}, nil
+	}
+
+	value, err := strconv.Atoi(t.text)
+	if t.typ != tokenNumber || err != nil || value < 0 {
+		return nil, p.errorAt(t, []string{"number", "parameter"}, "invalid value %s '%s'", keyword, t.text)
+	}
+
+	return &Literal{Kind: LiteralNumber, Value: strconv.Itoa(value)}, nil
+}
+
+func (p *queryParser) parseSelectList(stmt *selectStatement) error {
+
+	if p.peek().typ == tokenStar {
+		p.next()
+		stmt.all = true
+		return nil
+	}
+
+	for {
+		t := p.peek()
+		if !isName(t) {
+			return p.errorAt(t, []string{"column", "'*'"}, "select requires column list or * for all, found %s", t)
+		}
+
+		item := &selectItem{}
+		if _, ok := functions[strings.ToLower(t.text)]; ok && !t.quoted && p.peekAt(1).typ == tokenLParen {
+			call, err := p.parseFunction()
+			if err != nil {
+				return err
+			}
+			if containsAggregate(call) {
+				return p.errorAtExpr(firstAggregate(call), "aggregates cannot be used as function arguments in select")
+			}
+			item.expr = call
+		} else if !t.quoted && p.peekAt(1).typ == tokenLParen {
+			aggregate, err := p.parseAggregate()
+			if err != nil {
+				return err
+			}
+			item.expr = aggregate
+		} else {
+			column, err := p.parseColumnRef()
+			if err != nil {
+				return err
+			}
+			item.expr = column
+		}
+
+		if p.acceptKeyword(kwAs) || isName(p.peek()) {
+			alias := p.peek()
+			if !isName(alias) {
+				return p.errorAt(alias, []string{"alias"}, "alias expected, found %s", alias)
+			}
+			item.alias = p.next().value
+		}
+		stmt.items = append(stmt.items, item)
+
+		if p.peek().typ != tokenComma {
+			return nil
+		}
+		p.next()
+	}
+}
+
+// parseAggregate reads a call to an aggregate function
+func (p *queryParser) parseAggregate() (*AggregateExpr, error) {
+
+	t := p.next()
+	fn := strings.ToLower(t.text)
+	if _, ok := aggregateMap[fn]; !ok && fn != kwCount {
+		return nil, p.errorAt(t, nil, "unknown function '%s'", t.text)
+	}
+	p.next()
+
+	aggregate := &AggregateExpr{Func: fn, pos: t.pos}
+
+	if p.peek().typ == tokenStar {
+		if fn != kwCount {
+			return nil, p.errorAt(p.peek(), []string{"column"}, "* is only supported by count, found %s(*)", t.text)
+		}
+		p.next()
+	} else {
+		if p.acceptKeyword(kwDistinct) {
+			if fn != kwCount {
+				return nil, p.errorAt(p.tokens[p.pos-1], []string{"column"}, "distinct is only supported by count, found %s(distinct ...)", t.text)
+			}
+			aggregate.Distinct = true
+		}
+
+		column := p.peek()
+		if !isName(column) {
+			return nil, p.errorAt(column, []string{"column"}, "column expected in %s, found %s", t.text, column)
+		}
+		var err error
+		aggregate.Column, err = p.parseColumnRef()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if r := p.peek(); r.typ != tokenRParen {
+		return nil, p.errorAt(r, []string{"')'"}, "missing ')', found %s", r)
+	}
+	p.next()
+
+	return aggregate, nil
+}
+
+// parseFunction reads a call to one of the canonical functions and checks its
+// arguments
+func (p *queryParser) parseFunction() (*FunctionCall, error) {
+
+	t := p.next()
+	p.next()
+
+	call := &FunctionCall{fn: functions[strings.ToLower(t.text)], Name: strings.ToLower(t.text), pos: t.pos}
+
+	if p.peek().typ != tokenRParen {
+		for {
+			arg, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			call.Args = append(call.Args, arg)
+
+			if p.peek().typ != tokenComma {
+				break
+			}
+			p.next()
+		}
+	}
+
+	if r := p.peek(); r.typ != tokenRParen {
+		return nil, p.errorAt(r, []string{"')'"}, "missing ')', found %s", r)
+	}
+	p.next()
+
+	if problem := checkArgs(call); problem != "" {
+		return nil, p.errorAtExpr(call, "%s", problem)
+	}
+
+	return call, nil
+}
+
+func (p *queryParser) parseGroupBy() ([]*ColumnRef, error) {
+
+	var columns []*ColumnRef
+
+	for {
+		t := p.peek()
+		if !isName(t) {
+			return nil, p.errorAt(t, []string{"column"}, "column expected in group by, found %s", t)
+		}
+		column, err := p.parseColumnRef()
+		if err != nil {
+			return nil, err
+		}
+		columns = append(columns, column)
+
+		if p.peek().typ != tokenComma {
+			return columns, nil
+		}
+		p.next()
+	}
+}
+
+func (p *queryParser) parseWhere() (Expression, error) {
+
+	if t := p.peek(); t.typ == tokenEOF || (isReservedToken(t) && !p.isKeyword(kwNot)) {
+		return nil, p.errorAt(t, []string{"condition"}, "empty where clause")
+	}
+
+	return p.parseOr()
+}
+
+func (p *queryParser) parseOr() (Expression, error) {
+
+	expr, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.acceptKeyword(kwOr) {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+
+		expr = &LogicalExpr{Op: kwOr, Left: expr, Right: right}
+	}
+
+	return expr, nil
+}
+
+func (p *queryParser) parseAnd() (Expression, error) {
+
+	expr, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.acceptKeyword(kwAnd) {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+
+		expr = &LogicalExpr{Op: kwAnd, Left: expr, Right: right}
+	}
+
+	return expr, nil
+}
+
+func (p *queryParser) parseNot() (Expression, error) {
+
+	if p.acceptKeyword(kwNot) {
+		expr, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &NotExpr{Expr: expr}, nil
+	}
+
+	if t := p.peek(); t.typ == tokenLParen {
+		p.next()
+
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		if t := p.peek(); t.typ != tokenRParen {
+			return nil, p.errorAt(t, []string{"')'"}, "missing ')', found %s", t)
+		}
+		p.next()
+
+		return expr, nil
+	}
+
+	return p.parseComparison()
+}
+
+func (p *queryParser) parseComparison() (Expression, error) {
+
+	start := p.peek()
+
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.acceptKeyword(kwIs) {
+		not := p.acceptKeyword(kwNot)
+		if !p.acceptKeyword(kwNull) {
+			return nil, p.errorAt(p.peek(), []string{kwNull}, "null expected after is, found %s", p.peek())
+		}
+		return &IsNullExpr{Expr: left, Not: not}, nil
+	}
+
+	not := false
+	if p.isKeyword(kwNot) {
+		next := p.peekAt(1)
+		if !isKeywordToken(next, kwIn) && !isKeywordToken(next, kwBetween) && !isKeywordToken(next, kwLike) {
+			return nil, p.errorAt(next, []string{kwIn, kwBetween, kwLike}, "in, between or like expected after not, found %s", next)
+		}
+		p.next()
+		not = true
+	}
+
+	if p.acceptKeyword(kwIn) {
+		return p.parseIn(left, not)
+	}
+
+	if p.acceptKeyword(kwBetween) {
+		low, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		if !p.acceptKeyword(kwAnd) {
+			return nil, p.errorAt(p.peek(), []string{kwAnd}, "and expected in between, found %s", p.peek())
+		}
+		high, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		typeStringLiteral(left, low)
+		typeStringLiteral(left, high)
+		return &BetweenExpr{Expr: left, Low: low, High: high, Not: not}, nil
+	}
+
+	if p.acceptKeyword(kwLike) {
+		pt := p.peek()
+		pattern, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		switch e := pattern.(type) {
+		case *Parameter:
+		case *Literal:
+			if e.Kind != LiteralString {
+				return nil, p.errorAt(pt, []string{"string", "parameter"}, "like requires a string pattern, found %s", pt)
+			}
+			if problem := likePatternProblem(e.Value); problem != "" {
+				return nil, p.errorAt(pt, nil, "%s", problem)
+			}
+		default:
+			return nil, p.errorAt(pt, []string{"string", "parameter"}, "like requires a string pattern, found %s", pt)
+		}
+		return &LikeExpr{Expr: left, Pattern: pattern, Not: not}, nil
+	}
+
+	t := p.peek()
+	if t.typ != tokenOperator {
+		return nil, p.errorAt(t, []string{"operator", kwIs, kwIn, kwBetween, kwLike}, "invalid where clause, operator expected after '%s' but found %s", start.text, t)
+	}
+	p.next()
+
+	if _, ok := opMap[t.text]; !ok {
+		return nil, p.errorAt(t, []string{"operator"}, "unknown operator '%s'", t.text)
+	}
+
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	_, leftCall := left.(*FunctionCall)
+	_, rightCall := right.(*FunctionCall)
+	leftType, rightType := exprType(left), exprType(right)
+	if (leftCall || rightCall) && leftType != typeAny && rightType != typeAny && leftType != rightType {
+		return nil, p.errorAt(t, nil, "cannot compare a %s with a %s", leftType, rightType)
+	}
+
+	typeStringLiteral(left, right)
+	typeStringLiteral(right, left)
+
+	return &ComparisonExpr{Op: t.text, Left: left, Right: right}, nil
+}
+
+// typeStringLiteral turns a string compared with a column into a timestamp or
+// guid literal when it is written as one, e.g. '2025-01-31T10:00:00Z'. Strings
+// holding a date only are left as strings as a string column can store them,
+// date '2025-01-31' compares a date
+func typeStringLiteral(column Expression, value Expression) {
+
+	if _, ok := column.(*ColumnRef); !ok {
+		return
+	}
+	literal, ok := value.(*Literal)
+	if !ok || literal.Kind != LiteralString {
+		return
+	}
+
+	for _, kind := range []LiteralKind{LiteralTimestamp, LiteralGuid} {
+		if isTypedValue(kind, literal.Value) {
+			literal.Kind = kind
+			return
+		}
+	}
+}
+
+func (p *queryParser) parseIn(left Expression, not bool) (Expression, error) {
+
+	if t := p.peek(); t.typ != tokenLParen {
+		return nil, p.errorAt(t, []string{"'('"}, "'(' expected after in, found %s", t)
+	}
+	p.next()
+
+	in := &InExpr{Expr: left, Not: not}
+	for {
+		value, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		typeStringLiteral(left, value)
+		in.Values = append(in.Values, value)
+
+		t := p.next()
+		if t.typ == tokenRParen {
+			return in, nil
+		}
+		if t.typ != tokenComma {
+			return nil, p.errorAt(t, []string{"','", "')'"}, "',' or ')' expected in value list, found %s", t)
+		}
+	}
+}
+
+func (p *queryParser) parseOperand() (Expression, error) {
+
+	t := p.peek()
+
+	switch t.typ {
+	case tokenString:
+		p.next()
+		return &Literal{Kind: LiteralString, Value: t.value}, nil
+	case tokenNumber:
+		p.next()
+		return &Literal{Kind: LiteralNumber, Value: t.value}, nil
+	case tokenParam:
+		p.next()
+		return &Parameter{Name: t.value}, nil
+	case tokenOperator:
+		if t.text == "-" && p.tokens[p.pos+1].typ == tokenNumber {
+			p.next()
+			return &Literal{Kind: LiteralNumber, Value: "-" + p.next().value}, nil
+		}
+	case tokenIdent:
+		if t.quoted {
+			return p.parseColumnRef()
+		}
+		switch strings.ToLower(t.text) {
+		case kwTrue, kwFalse:
+			p.next()
+			return &Literal{Kind: LiteralBool, Value: strings.ToLower(t.text)}, nil
+		case kwNull:
+			p.next()
+			return &Literal{Kind: LiteralNull, Value: kwNull}, nil
+		}
+		if kind, ok := typedLiteralKinds[strings.ToLower(t.text)]; ok {
+			next := p.peekAt(1)
+			switch next.typ {
+			case tokenString:
+				if !isTypedValue(kind, next.value) {
+					return nil, p.errorAt(next, []string{typedLiteralFormats[kind]}, "invalid %s literal %s, expected %s", strings.ToLower(t.text), next, typedLiteralFormats[kind])
+				}
+				p.next()
+				p.next()
+				return &Literal{Kind: kind, Value: next.value}, nil
+			case tokenParam:
+				p.next()
+				p.next()
+				return &Parameter{Name: next.value, Kind: kind}, nil
+			}
+		}
+		if isName(t) {
+			if p.peekAt(1).typ == tokenLParen {
+				if _, ok := functions[strings.ToLower(t.text)]; ok {
+					return p.parseFunction()
+				}
+				return p.parseAggregate()
+			}
+			return p.parseColumnRef()
+		}
+	}
+
+	return nil, p.errorAt(t, nil, "invalid where clause, unexpected %s", t)
+}
+
+// parseColumnRef reads a column name optionally qualified with a table name
+// or alias, the caller has checked that the next token is an identifier
+func (p *queryParser) parseColumnRef() (*ColumnRef, error) {
+
+	start := p.next()
+	name := start.value
+
+	if p.peek().typ != tokenDot {
+		return &ColumnRef{Name: name, pos: start.pos}, nil
+	}
+	p.next()
+
+	t := p.peek()
+	if !isName(t) {
+		return nil, p.errorAt(t, []string{"column name"}, "column name expected after '%s.', found %s", name, t)
+	}
+	p.next()
+
+	return &ColumnRef{Table: name, Name: t.value, pos: start.pos}, nil
+}
+
+// acceptOrderBy accepts both the single keyword orderby and the standard order by
+func (p *queryParser) acceptOrderBy() bool {
+
+	if p.acceptKeyword(kwOrderBy) {
+		return true
+	}
+
+	if p.isKeyword(kwOrder) && isKeywordToken(p.peekAt(1), kwBy) {
+		p.next()
+		p.next()
+		return true
+	}
+
+	return false
+}
+
+func (p *queryParser) parseOrderBy() ([]*orderByItem, error) {
+
+	var items []*orderByItem
+
+	for {
+		t := p.peek()
+		if !isName(t) {
+			if len(items) == 0 {
+				return nil, p.errorAt(t, []string{"column"}, "value not found for orderby")
+			}
+			return nil, p.errorAt(t, []string{"column"}, "column expected in orderby, found %s", t)
+		}
+		column, err := p.parseColumnRef()
+		if err != nil {
+			return nil, err
+		}
+
+		item := &orderByItem{column: column}
+
+		if p.isKeyword(kwAsc) || p.isKeyword(kwDesc) {
+			item.direction = strings.ToLower(p.next().text)
+		} else if p.peek().typ == tokenParam {
+			item.directionParam = &Parameter{Name: p.next().value}
+		}
+
+		items = append(items, item)
+
+		if p.peek().typ != tokenComma {
+			return items, nil
+		}
+		p.next()
+	}
+}
+
+// isName reports whether the token can be used as a table, column or alias
+// name, quoted identifiers are never treated as keywords
+func isName(t token) bool {
+	return t.typ == tokenIdent && (t.quoted || !isReserved(t.text))
+}
+
+func isReservedToken(t token) bool {
+	return t.typ == tokenIdent && !t.quoted && isReserved(t.text)
+}
+
+// isReserved reports whether the word is a keyword of the query grammar and
+// therefore cannot be used as a table or column name
+func isReserved(word string) bool {
+
+	switch strings.ToLower(word) {
+	case kwSelect, kwTop, kwSkip, kwFrom, kwWhere, kwOrderBy, kwOrder, kwAsc, kwDesc,
+		kwLimit, kwOffset, kwFetch, kwGroup, kwHaving, kwAs, kwDistinct,
+		kwJoin, kwLeft, kwOuter, kwOn, kwExpand,
+		kwAnd, kwOr, kwNot, kwIn, kwBetween, kwLike, kwIs:
+		return true
+	}
+	return false
+}
+
+// firstAggregate returns the first aggregate of expr, or nil if there is none
+func firstAggregate(expr Expression) Expression {
+
+	var found Expression
+	walkExpr(expr, func(e Expression) {
+		if _, ok := e.(*AggregateExpr); ok && found == nil {
+			found = e
+		}
+	})
+
+	return found
+}
+
+func containsAggregate(expr Expression) bool {
+
+	found := false
+	walkExpr(expr, func(e Expression) {
+		if _, ok := e.(*AggregateExpr); ok {
+			found = true
+		}
+	})
+
+	return found
+}
+
+// validateAggregate checks that an aggregate query only selects, filters and
+// sorts by grouped columns and aggregates, and names the aggregates that were
+// not given an alias after their function and column, e.g. sum_amount
+func (p *queryParser) validateAggregate(stmt *selectStatement) error {
+
+	if stmt.all {
+		if len(stmt.groupBy) > 0 {
+			return p.errorAtExpr(stmt.groupBy[0], "select * cannot be used with group by or aggregates")
+		}
+		return p.errorAtExpr(stmt.having, "select * cannot be used with group by or aggregates")
+	}
+
+	grouped := make(map[string]bool)
+	for _, column := range stmt.groupBy {
+		grouped[strings.ToLower(column.Name)] = true
+	}
+
+	aliases := make(map[string]bool)
+	for _, item := range stmt.items {
+		switch e := item.expr.(type) {
+		case *ColumnRef:
+			if !grouped[strings.ToLower(e.Name)] {
+				return p.errorAtExpr(e, "column '%s' must appear in group by or be used in an aggregate", e.Name)
+			}
+		case *AggregateExpr:
+			if item.alias == "" {
+				item.alias = e.Func
+				if e.Column != nil {
+					item.alias += "_" + e.Column.Name
+				}
+			}
+			if aliases[strings.ToLower(item.alias)] || grouped[strings.ToLower(item.alias)] {
+				return p.errorAtExpr(e, "duplicate column name '%s', use as to name the aggregate", item.alias)
+			}
+			aliases[strings.ToLower(item.alias)] = true
+		}
+	}
+
+	var err error
+	walkExpr(stmt.having, func(expr Expression) {
+		if e, ok := expr.(*ColumnRef); ok && err == nil {
+			name := strings.ToLower(e.Name)
+			if !grouped[name] && !aliases[name] && !isAggregateColumn(stmt.having, e) {
+				err = p.errorAtExpr(e, "column '%s' in having must appear in group by or be an aggregate", e.Name)
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, item := range stmt.orderBy {
+		name := strings.ToLower(item.column.Name)
+		if !grouped[name] && !aliases[name] {
+			return p.errorAtExpr(item.column, "orderby column '%s' must appear in group by or be an aggregate alias", item.column.Name)
+		}
+	}
+
+	return nil
+}
+
+// validateFunctions checks the functions of the select list, which are computed
+// client side from the columns they use, and names the functions that were
+// not given an alias after the function and its first column, e.g. toupper_name
+func (p *queryParser) validateFunctions(stmt *selectStatement) error {
+
+	if stmt.distinct || stmt.isAggregate() || len(stmt.joins) > 0 {
+		for _, item := range stmt.items {
+			if call, ok := item.expr.(*FunctionCall); ok {
+				return p.errorAtExpr(call, "functions in select cannot be used with distinct, group by, aggregates or joins")
+			}
+		}
+	}
+
+	names := make(map[string]bool)
+	for _, item := range stmt.items {
+		if column, ok := item.expr.(*ColumnRef); ok {
+			if item.alias != "" {
+				names[strings.ToLower(item.alias)] = true
+			} else {
+				names[strings.ToLower(column.Name)] = true
+			}
+		}
+	}
+
+	computed := make(map[string]bool)
+	for _, item := range stmt.items {
+		call, ok := item.expr.(*FunctionCall)
+		if !ok {
+			continue
+		}
+		if item.alias == "" {
+			var columns []string
+			walkExpr(call, func(expr Expression) {
+				if column, ok := expr.(*ColumnRef); ok {
+					columns = append(columns, column.Name)
+				}
+			})
+			item.alias = call.fn.name
+			if len(columns) > 0 {
+				item.alias += "_" + columns[0]
+			}
+		}
+		if names[strings.ToLower(item.alias)] || computed[strings.ToLower(item.alias)] {
+			return p.errorAtExpr(call, "duplicate column name '%s', use as to name the function", item.alias)
+		}
+		computed[strings.ToLower(item.alias)] = true
+	}
+
+	for _, item := range stmt.orderBy {
+		if item.column.Table == "" && computed[strings.ToLower(item.column.Name)] {
+			return p.errorAtExpr(item.column, "orderby column '%s' is computed by a function and cannot be sorted by the server", item.column.Name)
+		}
+	}
+
+	return nil
+}
+
+// validateDistinct checks that a select distinct query only sorts by selected
+// columns, as the rows are reduced to the selected columns before sorting
+func (p *queryParser) validateDistinct(stmt *selectStatement) error {
+
+	selected := make(map[string]bool)
+	for _, item := range stmt.items {
+		selected[strings.ToLower(item.expr.(*ColumnRef).Name)] = true
+	}
+
+	for _, item := range stmt.orderBy {
+		if !selected[strings.ToLower(item.column.Name)] {
+			return p.errorAtExpr(item.column, "orderby column '%s' must appear in the select list when using distinct", item.column.Name)
+		}
+	}
+
+	return nil
+}
+
+// isAggregateColumn reports whether the column is the argument of an aggregate in expr
+func isAggregateColumn(expr Expression, column *ColumnRef) bool {
+
+	found := false
+	walkExpr(expr, func(e Expression) {
+		if aggregate, ok := e.(*AggregateExpr); ok && aggregate.Column == column {
+			found = true
+		}
+	})
+
+	return found
+}
+
+// resolveColumns checks that qualified columns refer to the queried table and
+// lets orderby refer to the aliases of selected columns
+func (p *queryParser) resolveColumns(stmt *selectStatement) error {
+
+	var err error
+	check := func(expr Expression) {
+		if column, ok := expr.(*ColumnRef); ok && column.Table != "" && err == nil {
+			if !strings.EqualFold(column.Table, stmt.from) && !strings.EqualFold(column.Table, stmt.fromAlias) && stmt.joinOf(column) == nil {
+				err = p.errorAtExpr(column, "unknown table '%s' in column '%s.%s'", column.Table, column.Table, column.Name)
+			}
+		}
+	}
+
+	for _, item := range stmt.items {
+		walkExpr(item.expr, check)
+	}
+	for _, j := range stmt.joins {
+		walkExpr(j.on, check)
+	}
+	walkExpr(stmt.where, check)
+	for _, column := range stmt.groupBy {
+		walkExpr(column, check)
+	}
+	walkExpr(stmt.having, check)
+	for _, item := range stmt.orderBy {
+		walkExpr(item.column, check)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, item := range stmt.orderBy {
+		if item.column.Table != "" {
+			continue
+		}
+		for _, selected := range stmt.items {
+			if column, ok := selected.expr.(*ColumnRef); ok && selected.alias != "" && strings.EqualFold(selected.alias, item.column.Name) {
+				item.column = &ColumnRef{Table: column.Table, Name: column.Name, pos: item.column.pos}
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+// odataKeywords lists the words OData expressions reserve, they cannot be
+// used as property names in $filter, $orderby and $apply
+var odataKeywords = map[string]bool{
+	"and": true, "or": true, "not": true, "eq": true, "ne": true, "gt": true, "ge": true, "lt": true, "le": true,
+	"has": true, "add": true, "sub": true, "mul": true, "div": true, "mod": true,
+	"asc": true, "desc": true, "with": true, "as": true, kwTrue: true, kwFalse: true, kwNull: true,
+}
+
+// isODataName reports whether name can be written as is in OData expressions
+func isODataName(name string) bool {
+
+	if name == "" || odataKeywords[strings.ToLower(name)] {
+		return false
+	}
+	for i, r := range name {
+		if (i == 0 && !isIdentStart(r)) || !isIdentPart(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// validateNames checks that the names written in OData expressions are valid
+// OData identifiers. OData has no way to quote names, so columns with spaces or
+// special characters can only be read through $select
+func (p *queryParser) validateNames(stmt *selectStatement) error {
+
+	var err error
+	check := func(clause string) func(Expression) {
+		return func(expr Expression) {
+			if column, ok := expr.(*ColumnRef); ok && err == nil && !isODataName(column.Name) {
+				err = p.errorAtExpr(column, "column '%s' cannot be used in %s, OData only supports names made of letters, digits and _ outside of select", column.Name, clause)
+			}
+		}
+	}
+
+	for _, j := range stmt.joins {
+		if !isODataName(j.name) {
+			return newQueryError(p.query, j.pos, j.name, nil, fmt.Sprintf("'%s' cannot be joined, OData only supports names made of letters, digits and _ in $expand", j.name))
+		}
+	}
+
+	// the selected columns of aggregations, distinct columns and joined
+	// entities are written in $apply and $expand
+	for _, item := range stmt.items {
+		column, plain := item.expr.(*ColumnRef)
+		switch {
+		case !plain:
+			walkExpr(item.expr, check(kwSelect))
+		case stmt.isAggregate() || stmt.distinct || stmt.joinOf(column) != nil:
+			check(kwSelect)(column)
+		}
+	}
+	for _, j := range stmt.joins {
+		walkExpr(j.on, check(kwOn))
+	}
+	walkExpr(stmt.where, check(kwWhere))
+	for _, column := range stmt.groupBy {
+		check(groupByClause)(column)
+	}
+	walkExpr(stmt.having, check(kwHaving))
+	for _, item := range stmt.orderBy {
+		check(orderByClause)(item.column)
+	}
+
+	return err
+}
+
+// validateJoins checks that the on condition of each join relates the joined
+// entity to the from table. The whole condition filters the related rows, the
+// columns of the from table refer to the row the related rows are nested in.
+// Columns of joined entities can only be selected, as they are returned as
+// nested rows
+func (p *queryParser) validateJoins(stmt *selectStatement) error {
+
+	if stmt.distinct || stmt.isAggregate() {
+		return newQueryError(p.query, stmt.joins[0].pos, stmt.joins[0].name, nil, "join and expand cannot be used with distinct, group by or aggregates")
+	}
+
+	names := map[string]bool{strings.ToLower(stmt.from): true}
+	if stmt.fromAlias != "" {
+		names[strings.ToLower(stmt.fromAlias)] = true
+	}
+	for _, j := range stmt.joins {
+		for _, name := range []string{j.name, j.alias} {
+			if name == "" || (name == j.alias && strings.EqualFold(j.alias, j.name)) {
+				continue
+			}
+			if names[strings.ToLower(name)] {
+				return newQueryError(p.query, j.pos, j.name, nil, fmt.Sprintf("duplicate table name '%s'", name))
+			}
+			names[strings.ToLower(name)] = true
+		}
+	}
+
+	for _, j := range stmt.joins {
+		if j.on == nil {
+			continue
+		}
+
+		var err error
+		walkExpr(j.on, func(expr Expression) {
+			column, ok := expr.(*ColumnRef)
+			if !ok || err != nil {
+				return
+			}
+			if column.Table == "" {
+				err = p.errorAtExpr(column, "columns in the on clause of join %s must be qualified with a table name or alias, found '%s'", j.name, column.Name)
+			} else if other := stmt.joinOf(column); other != nil && other != j {
+				err = p.errorAtExpr(column, "the on clause of join %s can only use its columns and the columns of %s, found '%s.%s'", j.name, stmt.from, column.Table, column.Name)
+			}
+		})
+		if err != nil {
+			return err
+		}
+
+		related := false
+		for _, condition := range conjuncts(j.on) {
+			related = related || isRelationship(stmt, j, condition)
+		}
+		if !related {
+			return newQueryError(p.query, j.pos, j.name, nil, fmt.Sprintf("the on clause of join %s must compare a column of %s with a column of %s", j.name, stmt.from, j.name))
+		}
+	}
+
+	for _, item := range stmt.items {
+		if column := item.expr.(*ColumnRef); item.alias != "" && stmt.joinOf(column) != nil {
+			return p.errorAtExpr(column, "columns of joined entities cannot be renamed, found '%s.%s as %s'", column.Table, column.Name, item.alias)
+		}
+	}
+
+	var err error
+	check := func(expr Expression) {
+		if column, ok := expr.(*ColumnRef); ok && err == nil && stmt.joinOf(column) != nil {
+			err = p.errorAtExpr(column, "columns of joined entities can only be used in select and on, found '%s.%s'", column.Table, column.Name)
+		}
+	}
+	walkExpr(stmt.where, check)
+	for _, item := range stmt.orderBy {
+		walkExpr(item.column, check)
+	}
+
+	return err
+}
+
+// conjuncts splits a condition into the conditions combined with and
+func conjuncts(expr Expression) []Expression {
+
+	if e, ok := expr.(*LogicalExpr); ok && e.Op == kwAnd {
+		return append(conjuncts(e.Left), conjuncts(e.Right)...)
+	}
+	return []Expression{expr}
+}
+
+// isRelationship reports whether the condition compares a column of the from
+// table with a column of the joined entity for equality
+func isRelationship(stmt *selectStatement, j *join, expr Expression) bool {
+
+	e, ok := expr.(*ComparisonExpr)
+	if !ok || opMap[e.Op] != "eq" {
+		return false
+	}
+
+	left, leftOk := e.Left.(*ColumnRef)
+	right, rightOk := e.Right.(*ColumnRef)
+	if !leftOk || !rightOk {
+		return false
+	}
+
+	leftJoin, rightJoin := stmt.joinOf(left), stmt.joinOf(right)
+	return (leftJoin == nil && rightJoin == j) || (leftJoin == j && rightJoin == nil)
+}
@@ -0,0 +1,462 @@
+package sqlodata
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// sqlOps maps the OData comparison operators to the SQL operators
+var sqlOps = map[string]string{
+	"eq": "=",
+	"ne": "<>",
+	"gt": ">",
+	"ge": ">=",
+	"lt": "<",
+	"le": "<=",
+}
+
+// likeFunctions maps the OData string functions that test a substring to the
+// like patterns they translate to
+var likeFunctions = map[string]func(string) string{
+	"contains":   func(s string) string { return "%" + s + "%" },
+	"startswith": func(s string) string { return s + "%" },
+	"endswith":   func(s string) string { return "%" + s },
+}
+
+// ParseURL parses the URL of an OData query, as sent to the Yukon server, the
+// entity set is the last segment of the path. $filter is parsed into Where
+func ParseURL(rawURL string) (*ODataRequest, error) {
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid query: %s", err.Error())
+	}
+
+	request := &ODataRequest{Entity: path.Base(u.Path)}
+	if request.Entity == "." || request.Entity == "/" {
+		return nil, fmt.Errorf("invalid query: entity set not found in '%s'", rawURL)
+	}
+
+	options, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return nil, fmt.Errorf("invalid query: %s", err.Error())
+	}
+
+	for name, values := range options {
+		if !strings.HasPrefix(name, "$") {
+			continue
+		}
+		value := values[len(values)-1]
+
+		switch name {
+		case "$select":
+			for _, column := range strings.Split(value, ",") {
+				request.Select = append(request.Select, strings.TrimSpace(column))
+			}
+		case "$filter":
+			request.Filter = value
+			request.Where, err = parseFilter(value)
+		case "$orderby":
+			for _, item := range strings.Split(value, ",") {
+				request.OrderBy = append(request.OrderBy, strings.Join(strings.Fields(item), " "))
+			}
+		case "$top":
+			request.Top, err = parseCountOption(name, value)
+		case "$skip":
+			request.Skip, err = parseCountOption(name, value)
+		case "$expand":
+			request.Expand = value
+		case "$apply":
+			request.Apply = value
+		default:
+			err = fmt.Errorf("invalid query: unsupported option %s", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return request, nil
+}
+
+// parsedApply is an $apply option as written for aggregate and select
+// distinct queries, an optional filter followed by groupby, aggregate or both
+type parsedApply struct {
+	where      Expression
+	groupBy    []string
+	aggregates []*aggregateColumn
+}
+
+// parseApply parses the $apply transformations queries translate to
+func parseApply(apply string) (*parsedApply, error) {
+
+	a := &parsedApply{}
+
+	steps := splitTopLevel(apply, '/')
+	for i, step := range steps {
+		step = strings.TrimSpace(step)
+		open := strings.Index(step, "(")
+		if open < 0 || !strings.HasSuffix(step, ")") {
+			return nil, fmt.Errorf("invalid query: invalid $apply transformation '%s'", step)
+		}
+		name, args := strings.TrimSpace(step[:open]), step[open+1:len(step)-1]
+		last := i == len(steps)-1
+
+		var err error
+		switch {
+		case name == "filter" && i == 0 && !last:
+			a.where, err = parseFilter(args)
+		case name == "groupby" && last:
+			err = a.parseGroupBy(args)
+		case name == "aggregate" && last:
+			err = a.parseAggregates(args)
+		default:
+			err = fmt.Errorf("invalid query: $apply transformation %s cannot be converted to a query", step)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return a, nil
+}
+
+// parseGroupBy parses the arguments of groupby, '(columns)' optionally
+// followed by aggregate(...)
+func (a *parsedApply) parseGroupBy(args string) error {
+
+	parts := splitTopLevel(args, ',')
+	columns := strings.TrimSpace(parts[0])
+	if len(parts) > 2 || !strings.HasPrefix(columns, "(") || !strings.HasSuffix(columns, ")") {
+		return fmt.Errorf("invalid query: groupby(%s) cannot be converted to a query", args)
+	}
+
+	for _, column := range strings.Split(columns[1:len(columns)-1], ",") {
+		a.groupBy = append(a.groupBy, strings.TrimSpace(column))
+	}
+
+	if len(parts) == 2 {
+		aggregate := strings.TrimSpace(parts[1])
+		if !strings.HasPrefix(aggregate, "aggregate(") || !strings.HasSuffix(aggregate, ")") {
+			return fmt.Errorf("invalid query: groupby(%s) cannot be converted to a query", args)
+		}
+		return a.parseAggregates(aggregate[len("aggregate(") : len(aggregate)-1])
+	}
+
+	return nil
+}
+
+// parseAggregates parses the arguments of aggregate, '$count as alias' or
+// 'column with method as alias'
+func (a *parsedApply) parseAggregates(args string) error {
+
+	for _, item := range splitTopLevel(args, ',') {
+		fields := strings.Fields(item)
+
+		var column *aggregateColumn
+		switch {
+		case len(fields) == 3 && fields[0] == "$count" && fields[1] == kwAs:
+			column = &aggregateColumn{fn: kwCount}
+		case len(fields) == 5 && fields[1] == "with" && fields[3] == kwAs && fields[2] == "countdistinct":
+			column = &aggregateColumn{fn: kwCount, column: fields[0], distinct: true}
+		case len(fields) == 5 && fields[1] == "with" && fields[3] == kwAs:
+			for fn, method := range aggregateMap {
+				if method == fields[2] {
+					column = &aggregateColumn{fn: fn, column: fields[0]}
+				}
+			}
+		}
+		if column == nil {
+			return fmt.Errorf("invalid query: aggregate '%s' cannot be converted to a query", strings.TrimSpace(item))
+		}
+
+		column.alias = fields[len(fields)-1]
+		column.hidden = strings.HasPrefix(column.alias, hiddenAliasPrefix)
+		a.aggregates = append(a.aggregates, column)
+	}
+
+	return nil
+}
+
+func parseCountOption(name string, value string) (*int, error) {
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid query: invalid value %s '%s'", name, value)
+	}
+	return &n, nil
+}
+
+// parseFilter parses a $filter option, the grammar is the subset of OData
+// that has an equivalent in queries: comparisons, the logical operators, the
+// canonical functions and contains, startswith and endswith, which become like
+// predicates. Comparisons with null become is null predicates
+func parseFilter(filter string) (Expression, error) {
+
+	tokens, err := lexFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &queryParser{query: filter, tokens: tokens}
+
+	expr, err := p.parseFilterOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if t := p.peek(); t.typ != tokenEOF {
+		return nil, p.errorAt(t, []string{kwAnd, kwOr}, "unexpected %s in $filter", t)
+	}
+
+	return expr, nil
+}
+
+func (p *queryParser) parseFilterOr() (Expression, error) {
+
+	left, err := p.parseFilterAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.acceptKeyword(kwOr) {
+		right, err := p.parseFilterAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &LogicalExpr{Op: kwOr, Left: left, Right: right}
+	}
+
+	return left, nil
+}
+
+func (p *queryParser) parseFilterAnd() (Expression, error) {
+
+	left, err := p.parseFilterNot()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.acceptKeyword(kwAnd) {
+		right, err := p.parseFilterNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &LogicalExpr{Op: kwAnd, Left: left, Right: right}
+	}
+
+	return left, nil
+}
+
+func (p *queryParser) parseFilterNot() (Expression, error) {
+
+	if p.acceptKeyword(kwNot) {
+		expr, err := p.parseFilterNot()
+		if err != nil {
+			return nil, err
+		}
+		return &NotExpr{Expr: expr}, nil
+	}
+
+	return p.parseFilterComparison()
+}
+
+func (p *queryParser) parseFilterComparison() (Expression, error) {
+
+	start := p.peek()
+
+	left, err := p.parseFilterOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	t := p.peek()
+	op, ok := sqlOps[strings.ToLower(t.text)]
+	if t.typ != tokenIdent || t.quoted || !ok {
+		switch e := left.(type) {
+		case *ColumnRef:
+			// a boolean property
+			return &ComparisonExpr{Op: "=", Left: e, Right: &Literal{Kind: LiteralBool, Value: kwTrue}}, nil
+		case *Literal, *Parameter, *FunctionCall:
+			return nil, p.errorAt(t, []string{"operator"}, "operator expected after %s, found %s", start, t)
+		}
+		return left, nil
+	}
+	p.next()
+
+	right, err := p.parseFilterOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	// conditions compared with a boolean, e.g. contains(Name, 'a') eq false
+	if isCondition(left) || isCondition(right) {
+		condition, value := left, right
+		if isCondition(right) {
+			condition, value = right, left
+		}
+		if l, ok := value.(*Literal); ok && l.Kind == LiteralBool && (op == "=" || op == "<>") {
+			if (l.Value == kwTrue) == (op == "=") {
+				return condition, nil
+			}
+			return &NotExpr{Expr: condition}, nil
+		}
+		return nil, p.errorAt(t, nil, "conditions can only be compared with true or false")
+	}
+
+	// comparisons with null are written with is null in queries
+	if isNullLiteral(right) || isNullLiteral(left) {
+		operand := left
+		if isNullLiteral(left) {
+			operand = right
+		}
+		switch op {
+		case "=":
+			return &IsNullExpr{Expr: operand}, nil
+		case "<>":
+			return &IsNullExpr{Expr: operand, Not: true}, nil
+		}
+		return nil, p.errorAt(t, nil, "null can only be compared with eq or ne")
+	}
+
+	return &ComparisonExpr{Op: op, Left: left, Right: right}, nil
+}
+
+func (p *queryParser) parseFilterOperand() (Expression, error) {
+
+	t := p.peek()
+
+	switch t.typ {
+	case tokenLParen:
+		p.next()
+		expr, err := p.parseFilterOr()
+		if err != nil {
+			return nil, err
+		}
+		if r := p.peek(); r.typ != tokenRParen {
+			return nil, p.errorAt(r, []string{"')'"}, "missing ')', found %s", r)
+		}
+		p.next()
+		return expr, nil
+	case tokenString:
+		p.next()
+		return &Literal{Kind: LiteralString, Value: t.value}, nil
+	case tokenNumber:
+		p.next()
+		return &Literal{Kind: LiteralNumber, Value: t.value}, nil
+	case tokenTyped:
+		p.next()
+		return &Literal{Kind: t.kind, Value: t.value}, nil
+	case tokenOperator:
+		if t.text == "-" && p.peekAt(1).typ == tokenNumber {
+			p.next()
+			return &Literal{Kind: LiteralNumber, Value: "-" + p.next().value}, nil
+		}
+	case tokenIdent:
+		name := strings.ToLower(t.text)
+		next := p.peekAt(1)
+		switch {
+		case t.quoted:
+		case name == kwTrue || name == kwFalse:
+			p.next()
+			return &Literal{Kind: LiteralBool, Value: name}, nil
+		case name == kwNull:
+			p.next()
+			return &Literal{Kind: LiteralNull, Value: kwNull}, nil
+		case t.text == "$it" && next.text == "/" && p.peekAt(2).typ == tokenIdent:
+			p.next()
+			p.next()
+			return &ColumnRef{Table: t.text, Name: p.next().value, pos: t.pos}, nil
+		case next.typ == tokenLParen:
+			return p.parseFilterFunction()
+		}
+		p.next()
+		return &ColumnRef{Name: t.value, pos: t.pos}, nil
+	}
+
+	return nil, p.errorAt(t, nil, "unexpected %s in $filter", t)
+}
+
+// parseFilterFunction reads a call to a canonical function, contains,
+// startswith and endswith are turned into like predicates
+func (p *queryParser) parseFilterFunction() (Expression, error) {
+
+	t := p.next()
+	p.next()
+
+	name := strings.ToLower(t.text)
+	fn, ok := functions[name]
+	pattern, like := likeFunctions[name]
+	if !ok && !like {
+		return nil, p.errorAt(t, nil, "function %s has no equivalent in queries", t.text)
+	}
+
+	call := &FunctionCall{fn: fn, Name: name, pos: t.pos}
+
+	if p.peek().typ != tokenRParen {
+		for {
+			arg, err := p.parseFilterOperand()
+			if err != nil {
+				return nil, err
+			}
+			call.Args = append(call.Args, arg)
+
+			if p.peek().typ != tokenComma {
+				break
+			}
+			p.next()
+		}
+	}
+
+	if r := p.peek(); r.typ != tokenRParen {
+		return nil, p.errorAt(r, []string{"')'"}, "missing ')', found %s", r)
+	}
+	p.next()
+
+	if like {
+		text, ok := likeText(call)
+		if !ok {
+			return nil, p.errorAt(t, nil, "%s is only supported with a column and a string without %% or _", t.text)
+		}
+		return &LikeExpr{Expr: call.Args[0], Pattern: &Literal{Kind: LiteralString, Value: pattern(text)}}, nil
+	}
+
+	if problem := checkArgs(call); problem != "" {
+		return nil, p.errorAt(t, nil, "%s", problem)
+	}
+
+	return call, nil
+}
+
+// likeText returns the substring searched by contains, startswith or endswith
+// when it can be written as a like pattern
+func likeText(call *FunctionCall) (string, bool) {
+
+	if len(call.Args) != 2 {
+		return "", false
+	}
+	text, ok := call.Args[1].(*Literal)
+	if !ok || text.Kind != LiteralString || strings.ContainsAny(text.Value, "%_") {
+		return "", false
+	}
+
+	return text.Value, true
+}
+
+// isCondition reports whether expr is a predicate rather than a value
+func isCondition(expr Expression) bool {
+
+	switch expr.(type) {
+	case *LogicalExpr, *NotExpr, *ComparisonExpr, *InExpr, *BetweenExpr, *LikeExpr, *IsNullExpr:
+		return true
+	}
+	return false
+}
+
+func isNullLiteral(expr Expression) bool {
+	l, ok := expr.(*Literal)
+	return ok && l.Kind == LiteralNull
+}
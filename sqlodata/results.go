@@ -0,0 +1,131 @@
+package sqlodata
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// renameColumns replaces the fields of each result row by their aliases
+func renameColumns(results []interface{}, aliases []columnAlias) {
+
+	if len(aliases) == 0 {
+		return
+	}
+
+	for _, r := range results {
+		row, ok := r.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		values := make([]interface{}, len(aliases))
+		found := make([]bool, len(aliases))
+		for i, alias := range aliases {
+			key, ok := findKey(row, alias.field)
+			if !ok {
+				continue
+			}
+			values[i], found[i] = row[key], true
+			if !alias.keep {
+				delete(row, key)
+			}
+		}
+
+		for i, alias := range aliases {
+			if found[i] {
+				row[alias.alias] = values[i]
+			}
+		}
+	}
+}
+
+// computation computes the functions of the select list for each result row,
+// hidden lists the fields only fetched as function arguments
+type computation struct {
+	columns []*computedColumn
+	hidden  []string
+	params  map[string]interface{}
+}
+
+type computedColumn struct {
+	alias string
+	expr  Expression
+}
+
+// apply adds the computed columns to the result rows and removes the hidden fields
+func (c *computation) apply(results []interface{}) error {
+
+	evaluator := &rowEvaluator{params: c.params}
+
+	for _, r := range results {
+		row, ok := r.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		values := make([]interface{}, len(c.columns))
+		for i, column := range c.columns {
+			value, err := evaluator.eval(column.expr, row)
+			if err != nil {
+				return err
+			}
+			values[i] = value
+		}
+
+		for _, name := range c.hidden {
+			if key, ok := findKey(row, name); ok {
+				delete(row, key)
+			}
+		}
+
+		for i, column := range c.columns {
+			row[column.alias] = values[i]
+		}
+	}
+
+	return nil
+}
+
+// distinctRows removes the rows that are equal to a previous row, keeping the
+// order of the remaining rows
+func distinctRows(rows []interface{}) ([]map[string]interface{}, error) {
+
+	var results []map[string]interface{}
+	seen := make(map[string]bool)
+
+	for _, r := range rows {
+		row, ok := r.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		// maps are marshalled with sorted keys, so equal rows have equal keys
+		key, err := json.Marshal(row)
+		if err != nil {
+			return nil, err
+		}
+		if seen[string(key)] {
+			continue
+		}
+		seen[string(key)] = true
+		results = append(results, row)
+	}
+
+	return results, nil
+}
+
+// findKey returns the key of a row matching name, preferring an exact match
+func findKey(row map[string]interface{}, name string) (string, bool) {
+
+	if _, ok := row[name]; ok {
+		return name, true
+	}
+
+	for key := range row {
+		if strings.EqualFold(key, name) {
+			return key, true
+		}
+	}
+
+	return "", false
+}
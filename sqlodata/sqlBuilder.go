@@ -0,0 +1,433 @@
+package sqlodata
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ToSQL converts the URL of an OData query, e.g. found in the logs of the
+// Yukon server, to the equivalent query
+func ToSQL(rawURL string) (string, error) {
+
+	request, err := ParseURL(rawURL)
+	if err != nil {
+		return "", err
+	}
+
+	return request.SQL()
+}
+
+// SQL renders the request as a query that translates back to the same
+// request. Parameters of the where clause are rendered as parameters. $apply
+// becomes group by, the aggregates and having, or select distinct, and the
+// $filter of an expanded entity becomes the on clause of a left join
+func (r *ODataRequest) SQL() (string, error) {
+
+	expand, err := parseExpand(r.Expand)
+	if err != nil {
+		return "", err
+	}
+
+	where := r.Where
+	if where == nil && r.Filter != "" {
+		where, err = parseFilter(r.Filter)
+		if err != nil {
+			return "", err
+		}
+	}
+	if err := checkOuterColumns(where); err != nil {
+		return "", err
+	}
+
+	var apply *parsedApply
+	var having Expression
+	if r.Apply != "" {
+		if len(r.Select) > 0 || len(expand) > 0 {
+			return "", fmt.Errorf("invalid query: $select and $expand cannot be converted to a query together with $apply")
+		}
+		apply, err = parseApply(r.Apply)
+		if err != nil {
+			return "", err
+		}
+		if r.Filter != "" {
+			// the $filter of an aggregation applies to its results, the
+			// aggregates are referenced by their alias
+			having, err = parseFilter(r.Filter)
+			if err != nil {
+				return "", err
+			}
+			having = replaceAliases(having, apply.aggregates)
+		}
+		where = apply.where
+	}
+
+	// grouping by columns without aggregates or having is select distinct
+	distinct := r.Distinct || (apply != nil && len(apply.aggregates) == 0 && having == nil)
+
+	var b strings.Builder
+	b.WriteString(kwSelect)
+	if distinct {
+		b.WriteString(" " + kwDistinct)
+	}
+	if r.Top != nil {
+		fmt.Fprintf(&b, " %s %d", kwTop, *r.Top)
+	}
+	if r.Skip != nil {
+		fmt.Fprintf(&b, " %s %d", kwSkip, *r.Skip)
+	}
+
+	var columns []string
+	for _, column := range r.Select {
+		if column == allColumns {
+			columns = append(columns, allColumns)
+		} else {
+			columns = append(columns, quoteIdent(column))
+		}
+	}
+	if apply != nil {
+		for _, column := range apply.groupBy {
+			columns = append(columns, quoteIdent(column))
+		}
+		for _, column := range apply.aggregates {
+			if !column.hidden {
+				columns = append(columns, buildSQL(column.expr())+" "+kwAs+" "+quoteIdent(column.alias))
+			}
+		}
+	}
+	var expanded []string
+	for _, e := range expand {
+		for _, column := range e.columns {
+			expanded = append(expanded, quoteIdent(e.name)+"."+quoteIdent(column))
+		}
+	}
+	if len(expanded) > 0 && (len(columns) == 0 || columns[0] == allColumns) {
+		return "", fmt.Errorf("invalid query: $select of an expanded entity requires the columns of %s to be selected", r.Entity)
+	}
+	if len(columns) == 0 {
+		columns = []string{allColumns}
+	}
+	columns = append(columns, expanded...)
+	b.WriteString(" " + strings.Join(columns, ", "))
+
+	b.WriteString(" " + kwFrom + " " + quoteIdent(r.Entity))
+
+	var names []string
+	for _, e := range expand {
+		if e.filter == nil {
+			names = append(names, quoteIdent(e.name))
+			continue
+		}
+		// $it is the from table and the other columns are the columns of the
+		// expanded entity
+		walkExpr(e.filter, func(expr Expression) {
+			if column, ok := expr.(*ColumnRef); ok {
+				if column.Table == "$it" {
+					column.Table = r.Entity
+				} else {
+					column.Table = e.name
+				}
+			}
+		})
+		b.WriteString(" " + kwLeft + " " + kwJoin + " " + quoteIdent(e.name) + " " + kwOn + " " + buildSQL(e.filter))
+	}
+	if len(names) > 0 {
+		b.WriteString(" " + kwExpand + " " + strings.Join(names, ", "))
+	}
+
+	if where != nil {
+		b.WriteString(" " + kwWhere + " " + buildSQL(where))
+	}
+
+	if apply != nil && !distinct && len(apply.groupBy) > 0 {
+		groupBy := make([]string, len(apply.groupBy))
+		for i, column := range apply.groupBy {
+			groupBy[i] = quoteIdent(column)
+		}
+		b.WriteString(" " + groupByClause + " " + strings.Join(groupBy, ", "))
+	}
+	if having != nil {
+		b.WriteString(" " + kwHaving + " " + buildSQL(having))
+	}
+
+	if len(r.OrderBy) > 0 {
+		items := make([]string, len(r.OrderBy))
+		for i, item := range r.OrderBy {
+			fields := strings.Fields(item)
+			if len(fields) == 0 {
+				return "", fmt.Errorf("invalid query: empty $orderby item")
+			}
+			direction := ""
+			if last := strings.ToLower(fields[len(fields)-1]); len(fields) > 1 && (last == kwAsc || last == kwDesc) {
+				direction, fields = last, fields[:len(fields)-1]
+			}
+			items[i] = quoteIdent(strings.Join(fields, " "))
+			if direction != "" {
+				items[i] += " " + direction
+			}
+		}
+		b.WriteString(" " + orderByClause + " " + strings.Join(items, ", "))
+	}
+
+	return b.String(), nil
+}
+
+// checkOuterColumns rejects $it, which is only valid in the $filter of an
+// expanded entity
+func checkOuterColumns(expr Expression) error {
+
+	var err error
+	walkExpr(expr, func(e Expression) {
+		if column, ok := e.(*ColumnRef); ok && column.Table == "$it" && err == nil {
+			err = fmt.Errorf("invalid query: $it/%s can only be used in the $filter of an expanded entity", column.Name)
+		}
+	})
+
+	return err
+}
+
+// replaceAliases replaces the columns of a having clause that are aliases of
+// aggregates by the aggregates
+func replaceAliases(expr Expression, aggregates []*aggregateColumn) Expression {
+
+	replace := func(e Expression) Expression {
+		if column, ok := e.(*ColumnRef); ok && column.Table == "" {
+			for _, aggregate := range aggregates {
+				if strings.EqualFold(aggregate.alias, column.Name) {
+					return aggregate.expr()
+				}
+			}
+		}
+		return e
+	}
+
+	walkExpr(expr, func(e Expression) {
+		switch x := e.(type) {
+		case *ComparisonExpr:
+			x.Left, x.Right = replace(x.Left), replace(x.Right)
+		case *InExpr:
+			x.Expr = replace(x.Expr)
+			for i, value := range x.Values {
+				x.Values[i] = replace(value)
+			}
+		case *BetweenExpr:
+			x.Expr, x.Low, x.High = replace(x.Expr), replace(x.Low), replace(x.High)
+		case *LikeExpr:
+			x.Expr = replace(x.Expr)
+		case *IsNullExpr:
+			x.Expr = replace(x.Expr)
+		case *FunctionCall:
+			for i, arg := range x.Args {
+				x.Args[i] = replace(arg)
+			}
+		}
+	})
+
+	return replace(expr)
+}
+
+// expandedEntity is an entry of $expand, columns lists its $select and filter
+// is its $filter
+type expandedEntity struct {
+	name    string
+	columns []string
+	filter  Expression
+}
+
+// parseExpand splits $expand into its entities, only $select and $filter are
+// supported in the options of an entity
+func parseExpand(expand string) ([]*expandedEntity, error) {
+
+	var entities []*expandedEntity
+
+	for _, item := range splitTopLevel(expand, ',') {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		e := &expandedEntity{name: item}
+		if open := strings.Index(item, "("); open >= 0 {
+			if !strings.HasSuffix(item, ")") {
+				return nil, fmt.Errorf("invalid query: missing ')' in $expand '%s'", item)
+			}
+			e.name = strings.TrimSpace(item[:open])
+			for _, option := range splitTopLevel(item[open+1:len(item)-1], ';') {
+				name := strings.TrimSpace(option)
+				value := ""
+				if eq := strings.Index(option, "="); eq >= 0 {
+					name, value = strings.TrimSpace(option[:eq]), option[eq+1:]
+				}
+				switch name {
+				case "$select":
+					for _, column := range strings.Split(value, ",") {
+						e.columns = append(e.columns, strings.TrimSpace(column))
+					}
+				case "$filter":
+					filter, err := parseFilter(value)
+					if err != nil {
+						return nil, err
+					}
+					e.filter = filter
+				default:
+					return nil, fmt.Errorf("invalid query: %s of expanded entity %s cannot be converted to a query", name, e.name)
+				}
+			}
+		}
+		entities = append(entities, e)
+	}
+
+	return entities, nil
+}
+
+// splitTopLevel splits s at the separators that are not between parentheses
+// or in a string literal
+func splitTopLevel(s string, separator rune) []string {
+
+	var parts []string
+	depth, quoted, start := 0, false, 0
+	for i, r := range s {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+		case quoted:
+		case r == '(':
+			depth++
+		case r == ')':
+			depth--
+		case r == separator && depth == 0:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(parts, s[start:])
+}
+
+// buildSQL renders an expression of a where clause
+func buildSQL(expr Expression) string {
+
+	switch e := expr.(type) {
+	case *LogicalExpr:
+		return buildSQLOperand(e.Left, precedence(e)) + " " + e.Op + " " + buildSQLOperand(e.Right, precedence(e))
+	case *NotExpr:
+		switch inner := e.Expr.(type) {
+		case *InExpr:
+			if !inner.Not {
+				return buildSQL(&InExpr{Expr: inner.Expr, Values: inner.Values, Not: true})
+			}
+		case *BetweenExpr:
+			if !inner.Not {
+				return buildSQL(&BetweenExpr{Expr: inner.Expr, Low: inner.Low, High: inner.High, Not: true})
+			}
+		case *LikeExpr:
+			if !inner.Not {
+				return buildSQL(&LikeExpr{Expr: inner.Expr, Pattern: inner.Pattern, Not: true})
+			}
+		case *IsNullExpr:
+			return buildSQL(&IsNullExpr{Expr: inner.Expr, Not: !inner.Not})
+		case *NotExpr:
+			return buildSQL(inner.Expr)
+		}
+		return kwNot + " (" + buildSQL(e.Expr) + ")"
+	case *ComparisonExpr:
+		return buildSQL(e.Left) + " " + e.Op + " " + buildSQL(e.Right)
+	case *InExpr:
+		values := make([]string, len(e.Values))
+		for i, value := range e.Values {
+			values[i] = buildSQL(value)
+		}
+		return buildSQL(e.Expr) + notSQL(e.Not) + " " + kwIn + " (" + strings.Join(values, ", ") + ")"
+	case *BetweenExpr:
+		return buildSQL(e.Expr) + notSQL(e.Not) + " " + kwBetween + " " + buildSQL(e.Low) + " " + kwAnd + " " + buildSQL(e.High)
+	case *LikeExpr:
+		return buildSQL(e.Expr) + notSQL(e.Not) + " " + kwLike + " " + buildSQL(e.Pattern)
+	case *IsNullExpr:
+		if e.Not {
+			return buildSQL(e.Expr) + " " + kwIs + " " + kwNot + " " + kwNull
+		}
+		return buildSQL(e.Expr) + " " + kwIs + " " + kwNull
+	case *ColumnRef:
+		if e.Table != "" {
+			return quoteIdent(e.Table) + "." + quoteIdent(e.Name)
+		}
+		return quoteIdent(e.Name)
+	case *AggregateExpr:
+		if e.Column == nil {
+			return e.Func + "(*)"
+		}
+		if e.Distinct {
+			return e.Func + "(" + kwDistinct + " " + quoteIdent(e.Column.Name) + ")"
+		}
+		return e.Func + "(" + quoteIdent(e.Column.Name) + ")"
+	case *FunctionCall:
+		args := make([]string, len(e.Args))
+		for i, arg := range e.Args {
+			args[i] = buildSQL(arg)
+		}
+		return e.Name + "(" + strings.Join(args, ", ") + ")"
+	case *Literal:
+		switch e.Kind {
+		case LiteralString:
+			return quoteString(e.Value)
+		case LiteralDate, LiteralTimestamp, LiteralGuid:
+			return typedLiteralKeyword(e.Kind) + " " + quoteString(e.Value)
+		}
+		return e.Value
+	case *Parameter:
+		if e.Kind != LiteralString {
+			return typedLiteralKeyword(e.Kind) + " :" + e.Name
+		}
+		return ":" + e.Name
+	}
+
+	return ""
+}
+
+// typedLiteralKeyword returns the keyword that introduces a typed literal
+func typedLiteralKeyword(kind LiteralKind) string {
+
+	for keyword, k := range typedLiteralKinds {
+		if k == kind {
+			return keyword
+		}
+	}
+	return ""
+}
+
+// buildSQLOperand renders an operand of a logical operator, grouping it when
+// it binds more loosely than the operator itself
+func buildSQLOperand(expr Expression, parentPrecedence int) string {
+
+	if precedence(expr) < parentPrecedence {
+		return "(" + buildSQL(expr) + ")"
+	}
+	return buildSQL(expr)
+}
+
+func notSQL(not bool) string {
+	if not {
+		return " " + kwNot
+	}
+	return ""
+}
+
+// quoteIdent renders a table or column name, names that are not plain
+// identifiers or that are keywords are double quoted
+func quoteIdent(name string) string {
+
+	plain := name != "" && !isReserved(name)
+	switch strings.ToLower(name) {
+	case kwTrue, kwFalse, kwNull:
+		plain = false
+	}
+	for i, r := range name {
+		if (i == 0 && !isIdentStart(r)) || !isIdentPart(r) {
+			plain = false
+		}
+	}
+
+	if plain {
+		return name
+	}
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
@@ -0,0 +1,298 @@
+// Package sqlodata translates SQL select statements to OData query requests,
+// it implements the query dialect of the Yukon query activity so that other
+// services can reuse it
+//
+//	request, err := sqlodata.Translate("select Name from Account where Id = :id", params)
+//	uri := request.URL(base)
+//
+// Queries executed many times are parsed once and bound to the parameter
+// values of each execution
+//
+//	stmt, err := sqlodata.Parse(sql)
+//	request, err := stmt.Bind(params)
+//
+// Some queries are partly evaluated by the client, see ClientSide, Fallback,
+// Evaluate and Complete
+package sqlodata
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// ODataRequest is the OData request a query translates to, the fields are
+// rendered as the OData query options by URL
+type ODataRequest struct {
+	// Entity is the entity set read by the query, the table of the from clause
+	Entity string
+	// Select lists the selected properties, it is "*" when all the properties
+	// are selected and empty when the query aggregates rows with Apply
+	Select []string
+	// Where is the syntax tree of the where clause, nil without a where clause.
+	// Parameters are left as *Parameter nodes, Filter holds their values
+	Where Expression
+	// Filter is the $filter option, the where clause rendered with the values
+	// of the parameters or, for aggregations, the having clause
+	Filter string
+	// OrderBy lists the sort properties, each optionally followed by asc or desc
+	OrderBy []string
+	// Top and Skip are the paging options, nil when not set
+	Top  *int
+	Skip *int
+	// Apply is the $apply transformation of a query with aggregates, group by
+	// or select distinct columns, it is empty when the aggregates are ClientSide
+	Apply string
+	// Expand lists the related entities read with each entity
+	Expand string
+	// Distinct is set for select distinct queries
+	Distinct bool
+
+	aliases     []columnAlias
+	aggregation *aggregation
+	computation *computation
+	countOnly   bool
+}
+
+// columnAlias renames a field in the results, keep is set when the field is
+// also selected under its own name
+type columnAlias struct {
+	field string
+	alias string
+	keep  bool
+}
+
+// Statement is a parsed query, a query without parameters is also rendered
+// once, otherwise Bind renders it with the parameter values of each execution
+type Statement struct {
+	stmt   *selectStatement
+	static *ODataRequest
+}
+
+// Parse parses a query, the errors reporting a syntax error are *QueryError
+func Parse(sql string) (*Statement, error) {
+
+	stmt, err := parseStatement(sql)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Statement{stmt: stmt}
+
+	if !stmt.hasParams() {
+		s.static, err = buildQuery(stmt, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// Bind translates the statement using the values of the parameters it references
+func (s *Statement) Bind(params map[string]interface{}) (*ODataRequest, error) {
+
+	if s.static != nil {
+		return s.static.clone(), nil
+	}
+
+	return buildQuery(s.stmt, params)
+}
+
+// Translate parses a query and binds it to the values of its parameters
+func Translate(sql string, params map[string]interface{}) (*ODataRequest, error) {
+
+	s, err := Parse(sql)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.Bind(params)
+}
+
+// clone copies the request so the copy can be changed without changing r
+func (r *ODataRequest) clone() *ODataRequest {
+
+	c := *r
+	c.Select = append([]string(nil), r.Select...)
+	c.OrderBy = append([]string(nil), r.OrderBy...)
+	if r.Top != nil {
+		top := *r.Top
+		c.Top = &top
+	}
+	if r.Skip != nil {
+		skip := *r.Skip
+		c.Skip = &skip
+	}
+
+	return &c
+}
+
+// URL returns the URL of the request, base is the URL the entity set name is
+// appended to
+func (r *ODataRequest) URL(base string) string {
+
+	uri := strings.TrimSuffix(base, "/") + "/" + url.PathEscape(r.Entity)
+
+	var options []string
+	if len(r.Select) > 0 {
+		options = append(options, fmt.Sprintf("$select=%s", url.QueryEscape(strings.Join(r.Select, ", "))))
+	}
+	if r.Apply != "" {
+		options = append(options, fmt.Sprintf("$apply=%s", url.QueryEscape(r.Apply)))
+	}
+	if r.Expand != "" {
+		options = append(options, fmt.Sprintf("$expand=%s", url.QueryEscape(r.Expand)))
+	}
+	if r.Top != nil {
+		options = append(options, fmt.Sprintf("$top=%d", *r.Top))
+	}
+	if r.Skip != nil {
+		options = append(options, fmt.Sprintf("$skip=%d", *r.Skip))
+	}
+	if r.Filter != "" {
+		options = append(options, fmt.Sprintf("$filter=%s", url.QueryEscape(r.Filter)))
+	}
+	if len(r.OrderBy) > 0 {
+		options = append(options, fmt.Sprintf("$orderby=%s", url.QueryEscape(strings.Join(r.OrderBy, ", "))))
+	}
+
+	if len(options) > 0 {
+		uri += "?" + strings.Join(options, "&")
+	}
+
+	return uri
+}
+
+// ClientSide reports whether the request cannot be sent as is and its results
+// must be computed by Evaluate, this is the case for select distinct * and for
+// aggregates that $apply cannot express
+func (r *ODataRequest) ClientSide() bool {
+
+	if r.aggregation != nil {
+		return r.aggregation.clientOnly
+	}
+	return r.Distinct
+}
+
+// Fallback returns the request reading the rows Evaluate computes the results
+// from, when the request is client side or rejected by a server that does not
+// support $apply. It returns nil for requests that have no fallback. The rows
+// are read in pages sorted by all the columns read, so that rows the order does
+// not tell apart are identical. When the query names no column, e.g. count(*)
+// or select distinct *, the request selects "*" and is only sorted by all the
+// columns once OrderByRow is given one of its rows
+func (r *ODataRequest) Fallback() *ODataRequest {
+
+	if r.aggregation != nil {
+		if len(r.aggregation.fields) == 0 {
+			return &ODataRequest{Entity: r.Entity, Select: []string{allColumns}, Filter: r.aggregation.where, countOnly: true}
+		}
+		fields := r.aggregation.fields
+		return &ODataRequest{Entity: r.Entity, Select: fields, Filter: r.aggregation.where, OrderBy: stableOrder(nil, fields)}
+	}
+
+	if r.Distinct {
+		// top and skip are applied once the duplicates are removed
+		fallback := r.clone()
+		fallback.Top, fallback.Skip = nil, nil
+		fallback.OrderBy = stableOrder(r.OrderBy, r.Select)
+		return fallback
+	}
+
+	return nil
+}
+
+// OrderByRow sorts a Fallback request that selects "*" by the fields of a row
+// it returned. A request that only counts rows reads the first field only
+func (r *ODataRequest) OrderByRow(row map[string]interface{}) {
+
+	var fields []string
+	for name, value := range row {
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+			// expanded entities cannot be sorted on
+			continue
+		}
+		if isODataName(name) {
+			fields = append(fields, name)
+		}
+	}
+	sort.Strings(fields)
+
+	if r.countOnly && len(fields) > 0 {
+		fields = fields[:1]
+		r.Select = fields
+	}
+	r.OrderBy = stableOrder(r.OrderBy, fields)
+}
+
+// stableOrder appends the columns orderBy does not sort on to orderBy
+func stableOrder(orderBy []string, columns []string) []string {
+
+	result := append([]string(nil), orderBy...)
+	sorted := make(map[string]bool)
+	for _, item := range orderBy {
+		sorted[strings.ToLower(strings.Fields(item)[0])] = true
+	}
+
+	for _, column := range columns {
+		if column != allColumns && !sorted[strings.ToLower(column)] {
+			sorted[strings.ToLower(column)] = true
+			result = append(result, column)
+		}
+	}
+
+	return result
+}
+
+// Evaluate computes the results of the request from all the rows read by its
+// Fallback request
+func (r *ODataRequest) Evaluate(rows []interface{}) ([]interface{}, error) {
+
+	if r.aggregation != nil {
+		return r.aggregation.aggregate(rows, r.Top, r.Skip)
+	}
+
+	if !r.Distinct {
+		return rows, nil
+	}
+
+	// $apply=groupby cannot remove the duplicates of select distinct * as it
+	// needs the names of the columns
+	distinct, err := distinctRows(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	page := pageRows(distinct, r.Top, r.Skip)
+
+	results := make([]interface{}, len(page))
+	for i, row := range page {
+		results[i] = row
+	}
+
+	return results, nil
+}
+
+// Complete turns the rows returned for the request into the results of the
+// query, it computes the functions of the select list, removes the fields only
+// read to compute them and renames the aliased columns
+func (r *ODataRequest) Complete(results []interface{}) error {
+
+	if r.aggregation != nil {
+		r.aggregation.removeHidden(results)
+	}
+
+	if r.computation != nil {
+		err := r.computation.apply(results)
+		if err != nil {
+			return err
+		}
+	}
+
+	renameColumns(results, r.aliases)
+
+	return nil
+}
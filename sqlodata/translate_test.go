@@ -0,0 +1,1666 @@
+package sqlodata
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseQuery(t *testing.T) {
+
+	// basic select * query
+	_, err := Translate("select * from entity2", nil)
+	assert.Nil(t, err)
+
+	// messy select * query
+	_, err = Translate(" select  *  from  entity2 ", nil)
+	assert.Nil(t, err)
+
+	// select column query
+	_, err = Translate("select index from entity2", nil)
+	assert.Nil(t, err)
+
+	// select columns query
+	_, err = Translate("select index, prop1 from entity2", nil)
+	assert.Nil(t, err)
+
+	// select * query with top
+	_, err = Translate("select top 100 * from entity2", nil)
+	assert.Nil(t, err)
+
+	// select * query with skip first
+	_, err = Translate("select skip 100 * from entity2", nil)
+	assert.Nil(t, err)
+
+	// select * query with skip last
+	_, err = Translate("select * from entity2 skip 100", nil)
+	assert.Nil(t, err)
+
+	// select * query with top and skip
+	_, err = Translate("select top 100 skip 100 * from entity2", nil)
+	assert.Nil(t, err)
+
+	// select * query with where
+	_, err = Translate("select * from entity2 where index < 5", nil)
+	assert.Nil(t, err)
+
+	// select * query with where with and and or
+	_, err = Translate("select * from entity2 where index < 5 or prop1 == 'xxxxx'", nil)
+	assert.Nil(t, err)
+
+	// select * query with orderby
+	_, err = Translate("select * from entity2 orderby index", nil)
+	assert.Nil(t, err)
+
+	// select * query with orderby asc
+	_, err = Translate("select * from entity2 orderby index asc", nil)
+	assert.Nil(t, err)
+
+	// select * query with orderby desc
+	_, err = Translate("select * from entity2 orderby index desc", nil)
+	assert.Nil(t, err)
+
+	// messy big fat pig query
+	_, err = Translate(" Select top  100  skip  100  index , prop1 from  entity2 where index < 5 or prop1 == 'xxxxx'  orderby index   desc  ", nil)
+	assert.Nil(t, err)
+
+	// blank query
+	_, err = Translate("", nil)
+	assert.NotNil(t, err)
+
+	// only select
+	_, err = Translate("select", nil)
+	assert.NotNil(t, err)
+
+	// no columns
+	_, err = Translate("select from entity2", nil)
+	assert.NotNil(t, err)
+
+	// no from
+	_, err = Translate("select *", nil)
+	assert.NotNil(t, err)
+
+	// no table
+	_, err = Translate("select * from", nil)
+	assert.NotNil(t, err)
+
+	// no where values
+	_, err = Translate("select * from entity2 where", nil)
+	assert.NotNil(t, err)
+
+	// no orderby values
+	_, err = Translate("select * from entity2 orderby", nil)
+	assert.NotNil(t, err)
+}
+
+func TestBuildFilter(t *testing.T) {
+
+	build := func(where string) (string, error) {
+		stmt, err := parseStatement("select * from a where " + where)
+		if err != nil {
+			return "", err
+		}
+		b := &queryBuilder{}
+		return b.build(stmt.where)
+	}
+
+	// valid
+	for op, opStr := range opMap {
+		filter, err := build("a " + op + " b")
+		assert.Nil(t, err)
+		assert.Equal(t, "a "+opStr+" b", filter)
+	}
+
+	filter, err := build("a = b and c = d")
+	assert.Nil(t, err)
+	assert.Equal(t, "a eq b and c eq d", filter)
+
+	filter, err = build("a = b or c = d")
+	assert.Nil(t, err)
+	assert.Equal(t, "a eq b or c eq d", filter)
+
+	// and binds tighter than or
+	filter, err = build("a = 1 or b = 2 and c = 3")
+	assert.Nil(t, err)
+	assert.Equal(t, "a eq 1 or b eq 2 and c eq 3", filter)
+
+	filter, err = build("a = 1 and b = 2 or c = 3")
+	assert.Nil(t, err)
+	assert.Equal(t, "a eq 1 and b eq 2 or c eq 3", filter)
+
+	// parentheses
+	filter, err = build("(a = 1 or b = 2) and c = 3")
+	assert.Nil(t, err)
+	assert.Equal(t, "(a eq 1 or b eq 2) and c eq 3", filter)
+
+	filter, err = build("a = 1 and (b = 2 or (c = 3 and d = 4))")
+	assert.Nil(t, err)
+	assert.Equal(t, "a eq 1 and (b eq 2 or c eq 3 and d eq 4)", filter)
+
+	filter, err = build("((a = 1))")
+	assert.Nil(t, err)
+	assert.Equal(t, "a eq 1", filter)
+
+	// not
+	filter, err = build("not a = 1")
+	assert.Nil(t, err)
+	assert.Equal(t, "not (a eq 1)", filter)
+
+	filter, err = build("NOT (a = 1 or b = 2) and c = 3")
+	assert.Nil(t, err)
+	assert.Equal(t, "not (a eq 1 or b eq 2) and c eq 3", filter)
+
+	filter, err = build("not not a = 1")
+	assert.Nil(t, err)
+	assert.Equal(t, "not (not (a eq 1))", filter)
+
+	// invalid
+	_, err = build("")
+	assert.NotNil(t, err)
+
+	_, err = build("a")
+	assert.NotNil(t, err)
+
+	_, err = build("a =")
+	assert.NotNil(t, err)
+
+	_, err = build("a b")
+	assert.NotNil(t, err)
+
+	_, err = build("a ?? b")
+	assert.NotNil(t, err)
+
+	_, err = build("a = b ?? c = d")
+	assert.NotNil(t, err)
+
+	_, err = build("(a = 1 or b = 2")
+	assert.NotNil(t, err)
+
+	_, err = build("a = 1)")
+	assert.NotNil(t, err)
+
+	_, err = build("not")
+	assert.NotNil(t, err)
+}
+
+func TestParseQueryLiterals(t *testing.T) {
+
+	// string literal with a space
+	queryObj, err := Translate("select * from account where name = 'John Smith'", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "name eq 'John Smith'", queryObj.Filter)
+
+	// string literal with a comma
+	queryObj, err = Translate("select * from account where note = 'a, b'", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "note eq 'a, b'", queryObj.Filter)
+
+	// string literal with an escaped quote
+	queryObj, err = Translate("select * from account where name = 'O''Brien'", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "name eq 'O''Brien'", queryObj.Filter)
+
+	// string literal containing keywords
+	queryObj, err = Translate("select name from account where note = 'select from where orderby' orderby name", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "account", queryObj.Entity)
+	assert.Equal(t, "note eq 'select from where orderby'", queryObj.Filter)
+	assert.Equal(t, []string{"name"}, queryObj.OrderBy)
+
+	// column names starting with keywords
+	queryObj, err = Translate("select from_date, selected from account where from_date > 3", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"from_date", "selected"}, queryObj.Select)
+	assert.Equal(t, "from_date gt 3", queryObj.Filter)
+
+	// numbers, booleans and null
+	queryObj, err = Translate("select * from account where a = -1.5 and b = true or c != null", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "a eq -1.5 and b eq true or c ne null", queryObj.Filter)
+
+	// decimals need digits on both sides of the point in OData
+	queryObj, err = Translate("select * from account where a = .5 and b > -.25", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "a eq 0.5 and b gt -0.25", queryObj.Filter)
+
+	_, err = Translate("select * from account where x = 1. and y = 2", nil)
+	queryErr, ok := err.(*QueryError)
+	assert.True(t, ok)
+	assert.Equal(t, 1, queryErr.Line)
+	assert.Equal(t, 33, queryErr.Column)
+	assert.Equal(t, "1.", queryErr.Token)
+	assert.Equal(t, "invalid number '1.', digits expected after '.'", queryErr.Message)
+
+	// operators without spaces
+	queryObj, err = Translate("select index,prop1 from entity2 where index<=5", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"index", "prop1"}, queryObj.Select)
+	assert.Equal(t, "index le 5", queryObj.Filter)
+
+	// unterminated string
+	_, err = Translate("select * from account where name = 'John", nil)
+	assert.NotNil(t, err)
+
+	// unknown character
+	_, err = Translate("select * from account where name = #", nil)
+	assert.NotNil(t, err)
+}
+
+func TestParseQueryClauses(t *testing.T) {
+
+	queryObj, err := Translate(" Select top  100  skip  50  index , prop1 from  entity2 where index < 5 or prop1 == 'xxxxx'  orderby index   desc  ", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"index", "prop1"}, queryObj.Select)
+	assert.Equal(t, 100, *queryObj.Top)
+	assert.Equal(t, 50, *queryObj.Skip)
+	assert.Equal(t, "entity2", queryObj.Entity)
+	assert.Equal(t, "index lt 5 or prop1 eq 'xxxxx'", queryObj.Filter)
+	assert.Equal(t, []string{"index desc"}, queryObj.OrderBy)
+
+	queryObj, err = Translate("select * from entity2 skip 10", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{allColumns}, queryObj.Select)
+	assert.Equal(t, 10, *queryObj.Skip)
+
+	// invalid top value
+	_, err = Translate("select top x * from entity2", nil)
+	assert.NotNil(t, err)
+
+	// unknown operator
+	_, err = Translate("select * from entity2 where index - 5", nil)
+	assert.NotNil(t, err)
+
+	// incomplete condition
+	_, err = Translate("select * from entity2 where index < 5 and", nil)
+	assert.NotNil(t, err)
+
+	// params
+	queryObj, err = Translate("select * from entity2 where index < :id and prop1 = :idx", map[string]interface{}{"id": 5, "idx": 6})
+	assert.Nil(t, err)
+	assert.Equal(t, "index lt 5 and prop1 eq 6", queryObj.Filter)
+}
+
+func TestParseQueryParams(t *testing.T) {
+
+	build := func(where string, params map[string]interface{}) (string, error) {
+		queryObj, err := Translate("select * from a where "+where, params)
+		if err != nil {
+			return "", err
+		}
+		return queryObj.Filter, nil
+	}
+
+	// strings are quoted and escaped
+	filter, err := build("name = :name", map[string]interface{}{"name": "John Smith"})
+	assert.Nil(t, err)
+	assert.Equal(t, "name eq 'John Smith'", filter)
+
+	filter, err = build("name = :name", map[string]interface{}{"name": "x' or 1 eq 1 or name eq 'x"})
+	assert.Nil(t, err)
+	assert.Equal(t, "name eq 'x'' or 1 eq 1 or name eq ''x'", filter)
+
+	// numbers
+	filter, err = build("a = :a and b = :b and c = :c and d = :d", map[string]interface{}{"a": 42, "b": int64(-7), "c": 1.25, "d": json.Number("3.5")})
+	assert.Nil(t, err)
+	assert.Equal(t, "a eq 42 and b eq -7 and c eq 1.25 and d eq 3.5", filter)
+
+	// booleans and null
+	filter, err = build("a = :a and b is not null or c = :c", map[string]interface{}{"a": true, "c": nil})
+	assert.Nil(t, err)
+	assert.Equal(t, "a eq true and b ne null or c eq null", filter)
+
+	// time values
+	created := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	filter, err = build("created >= :created", map[string]interface{}{"created": created})
+	assert.Nil(t, err)
+	assert.Equal(t, "created ge 2025-01-02T03:04:05Z", filter)
+
+	// whole token matching
+	filter, err = build("a = :id and b = :idx", map[string]interface{}{"id": 1, "idx": "x"})
+	assert.Nil(t, err)
+	assert.Equal(t, "a eq 1 and b eq 'x'", filter)
+
+	// in list
+	filter, err = build("status in (:s1, :s2)", map[string]interface{}{"s1": "A", "s2": "B"})
+	assert.Nil(t, err)
+	assert.Equal(t, "(status eq 'A' or status eq 'B')", filter)
+
+	// unused params are ignored
+	_, err = build("a = :a", map[string]interface{}{"a": 1, "b": 2})
+	assert.Nil(t, err)
+
+	// missing param
+	_, err = build("a = :a and b = :b", map[string]interface{}{"a": 1})
+	assert.NotNil(t, err)
+
+	_, err = build("a = :a", nil)
+	assert.NotNil(t, err)
+
+	// unsupported type
+	_, err = build("a = :a", map[string]interface{}{"a": map[string]interface{}{}})
+	assert.NotNil(t, err)
+}
+
+func TestBuildFilterPredicates(t *testing.T) {
+
+	build := func(where string, params map[string]interface{}) (string, error) {
+		queryObj, err := Translate("select * from a where "+where, params)
+		if err != nil {
+			return "", err
+		}
+		return queryObj.Filter, nil
+	}
+
+	// in
+	filter, err := build("status in ('A','B')", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "(status eq 'A' or status eq 'B')", filter)
+
+	filter, err = build("status in ('A')", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "status eq 'A'", filter)
+
+	filter, err = build("status not in ('A', 'B') and x = 1", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "(status ne 'A' and status ne 'B') and x eq 1", filter)
+
+	// between
+	filter, err = build("amount between 10 and 20 and x = 1", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "(amount ge 10 and amount le 20) and x eq 1", filter)
+
+	filter, err = build("amount not between 10 and 20", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "(amount lt 10 or amount gt 20)", filter)
+
+	// like
+	filter, err = build("name like 'Acme%'", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "startswith(name, 'Acme')", filter)
+
+	filter, err = build("name like '%Acme'", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "endswith(name, 'Acme')", filter)
+
+	filter, err = build("name like '%Acme%'", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "contains(name, 'Acme')", filter)
+
+	filter, err = build("name not like 'Acme%'", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "not startswith(name, 'Acme')", filter)
+
+	filter, err = build("name like 'Acme'", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "name eq 'Acme'", filter)
+
+	filter, err = build("name like :pattern", map[string]interface{}{"pattern": "%O'Brien"})
+	assert.Nil(t, err)
+	assert.Equal(t, "endswith(name, 'O''Brien')", filter)
+
+	_, err = build("name like 'Ac%me'", nil)
+	assert.NotNil(t, err)
+
+	_, err = build("name like 'Acm_'", nil)
+	assert.NotNil(t, err)
+
+	_, err = build("name like 5", nil)
+	assert.NotNil(t, err)
+
+	// is null
+	filter, err = build("closedDate is null", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "closedDate eq null", filter)
+
+	filter, err = build("closedDate is not null or a = 1", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "closedDate ne null or a eq 1", filter)
+
+	// invalid
+	_, err = build("status in 'A'", nil)
+	assert.NotNil(t, err)
+
+	_, err = build("status in ('A' 'B')", nil)
+	assert.NotNil(t, err)
+
+	_, err = build("status in ()", nil)
+	assert.NotNil(t, err)
+
+	_, err = build("amount between 10", nil)
+	assert.NotNil(t, err)
+
+	_, err = build("closedDate is 5", nil)
+	assert.NotNil(t, err)
+
+	_, err = build("status not = 5", nil)
+	assert.NotNil(t, err)
+}
+
+func TestParseQueryPagingParams(t *testing.T) {
+
+	params := map[string]interface{}{"pageSize": 20, "offset": 40.0, "dir": "DESC"}
+
+	queryObj, err := Translate("select top :pageSize skip :offset * from account orderby name :dir", params)
+	assert.Nil(t, err)
+	assert.Equal(t, 20, *queryObj.Top)
+	assert.Equal(t, 40, *queryObj.Skip)
+	assert.Equal(t, []string{"name desc"}, queryObj.OrderBy)
+
+	queryObj, err = Translate("select * from account top :pageSize skip :offset", map[string]interface{}{"pageSize": "5", "offset": json.Number("0")})
+	assert.Nil(t, err)
+	assert.Equal(t, 5, *queryObj.Top)
+	assert.Equal(t, 0, *queryObj.Skip)
+
+	// empty direction
+	queryObj, err = Translate("select * from account orderby name :dir", map[string]interface{}{"dir": ""})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"name"}, queryObj.OrderBy)
+
+	// invalid values
+	_, err = Translate("select top :pageSize * from account", map[string]interface{}{"pageSize": -1})
+	assert.NotNil(t, err)
+
+	_, err = Translate("select top :pageSize * from account", map[string]interface{}{"pageSize": 1.5})
+	assert.NotNil(t, err)
+
+	_, err = Translate("select skip :offset * from account", map[string]interface{}{"offset": "ten"})
+	assert.NotNil(t, err)
+
+	_, err = Translate("select top :pageSize * from account", nil)
+	assert.NotNil(t, err)
+
+	_, err = Translate("select * from account orderby name :dir", map[string]interface{}{"dir": "sideways"})
+	assert.NotNil(t, err)
+
+	_, err = Translate("select * from account orderby name :dir", map[string]interface{}{"dir": 1})
+	assert.NotNil(t, err)
+}
+
+func TestPrepareQuery(t *testing.T) {
+
+	// static query is rendered once
+	pq, err := Parse("select * from account where name = 'Acme'")
+	assert.Nil(t, err)
+	assert.NotNil(t, pq.static)
+
+	queryObj, err := pq.Bind(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "name eq 'Acme'", queryObj.Filter)
+
+	queryObj.Filter = "changed"
+	queryObj, err = pq.Bind(map[string]interface{}{"unused": 1})
+	assert.Nil(t, err)
+	assert.Equal(t, "name eq 'Acme'", queryObj.Filter)
+
+	// query with params is bound on each call
+	pq, err = Parse("select top :n * from account where name = :name")
+	assert.Nil(t, err)
+	assert.Nil(t, pq.static)
+
+	queryObj, err = pq.Bind(map[string]interface{}{"n": 1, "name": "a"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, *queryObj.Top)
+	assert.Equal(t, "name eq 'a'", queryObj.Filter)
+
+	queryObj, err = pq.Bind(map[string]interface{}{"n": 2, "name": "b"})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, *queryObj.Top)
+	assert.Equal(t, "name eq 'b'", queryObj.Filter)
+
+	_, err = pq.Bind(nil)
+	assert.NotNil(t, err)
+
+	// invalid queries fail when prepared
+	_, err = Parse("select * from account where")
+	assert.NotNil(t, err)
+
+	_, err = Parse("select * from account where name like 'a%b'")
+	assert.NotNil(t, err)
+}
+
+func TestParseQueryOrderBy(t *testing.T) {
+
+	queryObj, err := Translate("select * from contact orderby lastName asc, firstName asc, created desc", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"lastName asc", "firstName asc", "created desc"}, queryObj.OrderBy)
+
+	queryObj, err = Translate("select * from contact ORDER BY lastName, firstName DESC top 10", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"lastName", "firstName desc"}, queryObj.OrderBy)
+	assert.Equal(t, 10, *queryObj.Top)
+
+	queryObj, err = Translate("select * from contact where a = 1 order by lastName :dir1, created :dir2", map[string]interface{}{"dir1": "asc", "dir2": "desc"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"lastName asc", "created desc"}, queryObj.OrderBy)
+
+	// invalid
+	_, err = Translate("select * from contact order by", nil)
+	assert.NotNil(t, err)
+
+	_, err = Translate("select * from contact order lastName", nil)
+	assert.NotNil(t, err)
+
+	_, err = Translate("select * from contact orderby lastName,", nil)
+	assert.NotNil(t, err)
+
+	_, err = Translate("select * from contact orderby lastName, desc", nil)
+	assert.NotNil(t, err)
+}
+
+func TestParseQueryStandardPaging(t *testing.T) {
+
+	queryObj, err := Translate("select * from account order by name limit 10 offset 20", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 10, *queryObj.Top)
+	assert.Equal(t, 20, *queryObj.Skip)
+
+	queryObj, err = Translate("select * from account LIMIT :n OFFSET :m", map[string]interface{}{"n": 5, "m": 15})
+	assert.Nil(t, err)
+	assert.Equal(t, 5, *queryObj.Top)
+	assert.Equal(t, 15, *queryObj.Skip)
+
+	queryObj, err = Translate("select * from account order by name offset 20 rows fetch next 10 rows only", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 10, *queryObj.Top)
+	assert.Equal(t, 20, *queryObj.Skip)
+
+	queryObj, err = Translate("select * from account fetch first 1 row only", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, *queryObj.Top)
+	assert.Nil(t, queryObj.Skip)
+
+	queryObj, err = Translate("select * from account fetch first row only", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, *queryObj.Top)
+
+	queryObj, err = Translate("select * from account offset 5", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, *queryObj.Skip)
+
+	// invalid
+	_, err = Translate("select top 5 * from account limit 10", nil)
+	assert.NotNil(t, err)
+
+	_, err = Translate("select * from account offset 5 skip 5", nil)
+	assert.NotNil(t, err)
+
+	_, err = Translate("select * from account limit", nil)
+	assert.NotNil(t, err)
+
+	_, err = Translate("select * from account fetch 10 rows only", nil)
+	assert.NotNil(t, err)
+
+	_, err = Translate("select * from account fetch next 10 rows", nil)
+	assert.NotNil(t, err)
+
+	_, err = Translate("select * from account fetch next 10 only", nil)
+	assert.NotNil(t, err)
+
+	_, err = Translate("select limit 10 * from account", nil)
+	assert.NotNil(t, err)
+}
+
+func TestParseQueryAggregates(t *testing.T) {
+
+	queryObj, err := Translate("select count(*) from contact where status = 'A'", nil)
+	assert.Nil(t, err)
+	assert.Empty(t, queryObj.Select)
+	assert.Equal(t, "filter(status eq 'A')/aggregate($count as count)", queryObj.Apply)
+	assert.Equal(t, "", queryObj.Filter)
+
+	queryObj, err = Translate("select country, sum(amount) as total, count(*), avg(amount), min(amount), max(amount), count(distinct city) from account where x > 1 group by country having count(*) > 5 order by total desc top 3", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "filter(x gt 1)/groupby((country),aggregate(amount with sum as total,$count as count,amount with average as avg_amount,amount with min as min_amount,amount with max as max_amount,city with countdistinct as count_city))", queryObj.Apply)
+	assert.Equal(t, "count gt 5", queryObj.Filter)
+	assert.Equal(t, []string{"total desc"}, queryObj.OrderBy)
+	assert.Equal(t, 3, *queryObj.Top)
+
+	// group by without aggregates
+	queryObj, err = Translate("select country, city from account group by country, city", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "groupby((country,city))", queryObj.Apply)
+
+	// having on an aggregate that is not selected
+	queryObj, err = Translate("select country from account group by country having sum(amount) > :min", map[string]interface{}{"min": 100})
+	assert.Nil(t, err)
+	assert.Equal(t, "groupby((country),aggregate(amount with sum as _having1))", queryObj.Apply)
+	assert.Equal(t, "_having1 gt 100", queryObj.Filter)
+
+	// count of a column is evaluated client side
+	queryObj, err = Translate("select count(email) from contact", nil)
+	assert.Nil(t, err)
+	assert.True(t, queryObj.aggregation.clientOnly)
+	assert.Equal(t, []string{"email"}, queryObj.aggregation.fields)
+	assert.Equal(t, "", queryObj.Apply)
+
+	queryObj, err = Translate("select country, count(email) from contact where active = true group by country having count(email) > :min", map[string]interface{}{"min": 1})
+	assert.Nil(t, err)
+	assert.True(t, queryObj.ClientSide())
+	assert.Equal(t, "", queryObj.Apply)
+	assert.Equal(t, "", queryObj.Filter)
+	assert.Equal(t, "http://localhost/contact", queryObj.URL("http://localhost"))
+
+	_, err = Translate("select country, count(email) from contact group by country having count(email) > :min", nil)
+	assert.NotNil(t, err)
+
+	// invalid
+	_, err = Translate("select * from account group by country", nil)
+	assert.NotNil(t, err)
+
+	_, err = Translate("select country, city from account group by country", nil)
+	assert.NotNil(t, err)
+
+	_, err = Translate("select count(*) from account where count(*) > 1", nil)
+	assert.NotNil(t, err)
+
+	_, err = Translate("select sum(*) from account", nil)
+	assert.NotNil(t, err)
+
+	_, err = Translate("select sum(distinct amount) from account", nil)
+	assert.NotNil(t, err)
+
+	_, err = Translate("select median(amount) from account", nil)
+	assert.NotNil(t, err)
+
+	_, err = Translate("select country, count(*) from account group by country order by city", nil)
+	assert.NotNil(t, err)
+
+	_, err = Translate("select country, count(*) from account group by country having city = 'x'", nil)
+	assert.NotNil(t, err)
+
+	_, err = Translate("select count(*), count(*) from account", nil)
+	assert.NotNil(t, err)
+
+	_, err = Translate("select count(*) from account group", nil)
+	assert.NotNil(t, err)
+}
+
+func TestClientAggregation(t *testing.T) {
+
+	rows := []interface{}{
+		map[string]interface{}{"Country": "CA", "Amount": 10.0, "City": "Toronto"},
+		map[string]interface{}{"Country": "US", "Amount": 5.0, "City": "Austin"},
+		map[string]interface{}{"Country": "CA", "Amount": 30.0, "City": "Toronto"},
+		map[string]interface{}{"Country": "US", "Amount": nil, "City": "Boston"},
+		map[string]interface{}{"Country": "FR", "Amount": 1.0, "City": "Paris"},
+	}
+
+	queryObj, err := Translate("select country, count(*) as n, count(amount), sum(amount), avg(amount), min(city), max(amount), count(distinct city) from account group by country having count(*) > 1 order by country desc", nil)
+	assert.Nil(t, err)
+
+	results, err := queryObj.aggregation.aggregate(rows, queryObj.Top, queryObj.Skip)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(results))
+
+	us := results[0].(map[string]interface{})
+	assert.Equal(t, "US", us["country"])
+	assert.Equal(t, 2.0, us["n"])
+	assert.Equal(t, 1.0, us["count_amount"])
+	assert.Equal(t, 5.0, us["sum_amount"])
+	assert.Equal(t, 5.0, us["avg_amount"])
+	assert.Equal(t, "Austin", us["min_city"])
+	assert.Equal(t, 5.0, us["max_amount"])
+	assert.Equal(t, 2.0, us["count_city"])
+
+	ca := results[1].(map[string]interface{})
+	assert.Equal(t, "CA", ca["country"])
+	assert.Equal(t, 40.0, ca["sum_amount"])
+	assert.Equal(t, 20.0, ca["avg_amount"])
+	assert.Equal(t, 1.0, ca["count_city"])
+
+	// hidden having aggregate and paging
+	queryObj, err = Translate("select country from account group by country having sum(amount) >= 5 order by country skip 1 top 1", nil)
+	assert.Nil(t, err)
+
+	results, err = queryObj.aggregation.aggregate(rows, queryObj.Top, queryObj.Skip)
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{map[string]interface{}{"country": "US"}}, results)
+
+	// no rows without group by still returns a row
+	queryObj, err = Translate("select count(*), sum(amount) from account", nil)
+	assert.Nil(t, err)
+
+	results, err = queryObj.aggregation.aggregate(nil, nil, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{map[string]interface{}{"count": 0.0, "sum_amount": nil}}, results)
+}
+
+func TestParseQueryAliases(t *testing.T) {
+
+	queryObj, err := Translate("select a.Name as AccountName, a.Id, City Town from Account a where a.Status = 'A' order by AccountName desc", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "Account", queryObj.Entity)
+	assert.Equal(t, []string{"Name", "Id", "City"}, queryObj.Select)
+	assert.Equal(t, "Status eq 'A'", queryObj.Filter)
+	assert.Equal(t, []string{"Name desc"}, queryObj.OrderBy)
+	assert.Equal(t, []columnAlias{{field: "Name", alias: "AccountName"}, {field: "City", alias: "Town"}}, queryObj.aliases)
+
+	queryObj, err = Translate("select Account.Name, Name as Title from Account as acc order by acc.Name", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Name"}, queryObj.Select)
+	assert.Equal(t, []string{"Name"}, queryObj.OrderBy)
+	assert.Equal(t, []columnAlias{{field: "Name", alias: "Title", keep: true}}, queryObj.aliases)
+
+	queryObj, err = Translate("select c.Country as Land, count(*) as Total from Contact c group by c.Country order by Land", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "groupby((Country),aggregate($count as Total))", queryObj.Apply)
+	assert.Equal(t, []string{"Country"}, queryObj.OrderBy)
+	assert.Equal(t, []columnAlias{{field: "Country", alias: "Land"}}, queryObj.aliases)
+
+	// invalid
+	_, err = Translate("select b.Name from Account a", nil)
+	assert.NotNil(t, err)
+
+	_, err = Translate("select a. from Account a", nil)
+	assert.NotNil(t, err)
+
+	_, err = Translate("select Name as from Account", nil)
+	assert.NotNil(t, err)
+
+	_, err = Translate("select * from Account as where", nil)
+	assert.NotNil(t, err)
+}
+
+func TestRenameColumns(t *testing.T) {
+
+	results := []interface{}{
+		map[string]interface{}{"Name": "Acme", "id": 1.0, "City": "Austin"},
+		map[string]interface{}{"Name": "Initech", "id": 2.0},
+	}
+
+	renameColumns(results, []columnAlias{
+		{field: "name", alias: "AccountName"},
+		{field: "Id", alias: "Key", keep: true},
+		{field: "City", alias: "Town"},
+	})
+
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"AccountName": "Acme", "id": 1.0, "Key": 1.0, "Town": "Austin"},
+		map[string]interface{}{"AccountName": "Initech", "id": 2.0, "Key": 2.0},
+	}, results)
+}
+
+func TestParseQueryQuotedIdentifiers(t *testing.T) {
+
+	queryObj, err := Translate(`select "From", [Order Date] as [Date], `+"`Select`"+` from [Sales Order] s where s."From" = 'x' order by "From" desc`, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "Sales Order", queryObj.Entity)
+	assert.Equal(t, []string{"From", "Order Date", "Select"}, queryObj.Select)
+	assert.Equal(t, "From eq 'x'", queryObj.Filter)
+	assert.Equal(t, []string{"From desc"}, queryObj.OrderBy)
+	assert.Equal(t, []columnAlias{{field: "Order Date", alias: "Date"}}, queryObj.aliases)
+
+	// OData cannot quote names, names that are not identifiers can only be selected
+	names := []struct {
+		query   string
+		message string
+		column  int
+	}{
+		{`select Id from Orders where "Order Date" = 1`, "column 'Order Date' cannot be used in where, OData only supports names made of letters, digits and _ outside of select", 29},
+		{`select Id from Orders where year([Order Date]) = 2024`, "column 'Order Date' cannot be used in where, OData only supports names made of letters, digits and _ outside of select", 34},
+		{`select Id from Orders order by Id, [Order Date] desc`, "column 'Order Date' cannot be used in order by, OData only supports names made of letters, digits and _ outside of select", 36},
+		{`select [Order Date] as d from Orders order by d`, "column 'Order Date' cannot be used in order by, OData only supports names made of letters, digits and _ outside of select", 47},
+		{`select Id from Orders where [and] = 1`, "column 'and' cannot be used in where, OData only supports names made of letters, digits and _ outside of select", 29},
+		{`select [Ship Country], count(*) from Orders group by [Ship Country]`, "column 'Ship Country' cannot be used in select, OData only supports names made of letters, digits and _ outside of select", 8},
+		{`select distinct [Ship Country] from Orders`, "column 'Ship Country' cannot be used in select, OData only supports names made of letters, digits and _ outside of select", 17},
+		{`select o.Id, l.[Unit Price] from Orders o left join Lines l on o.Id = l.OrderId`, "column 'Unit Price' cannot be used in select, OData only supports names made of letters, digits and _ outside of select", 14},
+		{`select Id from Orders expand [Order Lines]`, "'Order Lines' cannot be joined, OData only supports names made of letters, digits and _ in $expand", 30},
+	}
+	for _, test := range names {
+		_, err = Parse(test.query)
+		queryErr, ok := err.(*QueryError)
+		if assert.True(t, ok, test.query) {
+			assert.Equal(t, test.message, queryErr.Message, test.query)
+			assert.Equal(t, test.column, queryErr.Column, test.query)
+		}
+	}
+
+	queryObj, err = Translate(`select [a]]b], "c""d" from "Count"`, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "Count", queryObj.Entity)
+	assert.Equal(t, []string{`a]b`, `c"d`}, queryObj.Select)
+
+	uri := (&ODataRequest{Select: []string{"Order Date"}, Entity: "Sales Order"}).URL("http://localhost/connections/1/query")
+	assert.Equal(t, "http://localhost/connections/1/query/Sales%20Order?$select=Order+Date", uri)
+
+	// invalid
+	_, err = Translate(`select "Name from Account`, nil)
+	assert.NotNil(t, err)
+
+	_, err = Translate("select [] from Account", nil)
+	assert.NotNil(t, err)
+
+	_, err = Translate("select Name from Account where [Name = 'x'", nil)
+	assert.NotNil(t, err)
+}
+
+func TestParseQueryDistinct(t *testing.T) {
+
+	queryObj, err := Translate("select distinct top 10 a.Country as Land, City from Account a where Status = 'A' order by Land", nil)
+	assert.Nil(t, err)
+	assert.True(t, queryObj.Distinct)
+	assert.Empty(t, queryObj.Select)
+	assert.Equal(t, "filter(Status eq 'A')/groupby((Country,City))", queryObj.Apply)
+	assert.Equal(t, "", queryObj.Filter)
+	assert.Equal(t, []string{"Country"}, queryObj.OrderBy)
+	assert.Equal(t, 10, *queryObj.Top)
+	assert.Equal(t, []string{"Country", "City"}, queryObj.aggregation.fields)
+
+	queryObj, err = Translate("select distinct * from Account order by Name", nil)
+	assert.Nil(t, err)
+	assert.True(t, queryObj.Distinct)
+	assert.Nil(t, queryObj.aggregation)
+	assert.Equal(t, []string{"*"}, queryObj.Select)
+	assert.Equal(t, []string{"Name"}, queryObj.OrderBy)
+
+	queryObj, err = Translate("select distinct Country, count(*) as total from Account group by Country", nil)
+	assert.Nil(t, err)
+	assert.True(t, queryObj.Distinct)
+	assert.Equal(t, "groupby((Country),aggregate($count as total))", queryObj.Apply)
+
+	// invalid
+	_, err = Translate("select distinct Country from Account order by City", nil)
+	assert.NotNil(t, err)
+
+	_, err = Translate("select distinct from Account", nil)
+	assert.NotNil(t, err)
+}
+
+func TestParseQueryJoins(t *testing.T) {
+
+	queryObj, err := Translate("select a.Name, c.FirstName, c.Email from Account a left outer join Contacts c on a.Id = c.AccountId and c.Active = true where a.Status = 'A' order by a.Name", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "Account", queryObj.Entity)
+	assert.Equal(t, []string{"Name"}, queryObj.Select)
+	assert.Equal(t, "Contacts($select=FirstName,Email;$filter=$it/Id eq AccountId and Active eq true)", queryObj.Expand)
+	assert.Equal(t, "Status eq 'A'", queryObj.Filter)
+	assert.Equal(t, []string{"Name"}, queryObj.OrderBy)
+
+	queryObj, err = Translate("select * from Orders o left join Lines on Lines.OrderId = o.Id left join Notes n on o.Id = n.OrderId and (n.Kind = :kind or o.Status = n.Status)", map[string]interface{}{"kind": "memo"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"*"}, queryObj.Select)
+	assert.Equal(t, "Lines($filter=OrderId eq $it/Id),Notes($filter=$it/Id eq OrderId and (Kind eq 'memo' or $it/Status eq Status))", queryObj.Expand)
+
+	queryObj, err = Translate("select Name, Contacts.Email from Account expand Contacts, Opportunities", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Name"}, queryObj.Select)
+	assert.Equal(t, "Contacts($select=Email),Opportunities", queryObj.Expand)
+
+	uri := (&ODataRequest{Select: []string{"Name"}, Entity: "Account", Expand: "Contacts($select=Email)"}).URL("http://localhost/connections/1/query")
+	assert.Equal(t, "http://localhost/connections/1/query/Account?$select=Name&$expand=Contacts%28%24select%3DEmail%29", uri)
+
+	// inner joins would return the rows without related rows
+	_, err = Translate("select * from Account a join Contacts c on a.Id = c.AccountId", nil)
+	queryErr, ok := err.(*QueryError)
+	if assert.True(t, ok) {
+		assert.Equal(t, "inner joins are not supported, rows without related rows are returned, use left join or expand", queryErr.Message)
+		assert.Equal(t, 25, queryErr.Column)
+		assert.Equal(t, []string{"left join", "expand"}, queryErr.Expected)
+	}
+
+	// the columns of the on clause must be qualified with the from table or the joined entity
+	_, err = Translate("select * from Account a left join Contacts c on a.Id = AccountId", nil)
+	queryErr, ok = err.(*QueryError)
+	if assert.True(t, ok) {
+		assert.Equal(t, "columns in the on clause of join Contacts must be qualified with a table name or alias, found 'AccountId'", queryErr.Message)
+		assert.Equal(t, 56, queryErr.Column)
+	}
+
+	_, err = Translate("select * from Account a left join Contacts c on a.Id = c.AccountId left join Notes n on n.ContactId = c.Id", nil)
+	queryErr, ok = err.(*QueryError)
+	if assert.True(t, ok) {
+		assert.Equal(t, "the on clause of join Notes can only use its columns and the columns of Account, found 'c.Id'", queryErr.Message)
+	}
+
+	// invalid
+	_, err = Translate("select * from Account a left join Contacts c", nil)
+	assert.NotNil(t, err)
+
+	_, err = Translate("select * from Account a left join Contacts c on c.Active = true", nil)
+	assert.NotNil(t, err)
+
+	_, err = Translate("select * from Account a left join Contacts c on a.Id = c.AccountId where c.Active = true", nil)
+	assert.NotNil(t, err)
+
+	_, err = Translate("select c.Email as Mail from Account a left join Contacts c on a.Id = c.AccountId", nil)
+	assert.NotNil(t, err)
+
+	_, err = Translate("select count(*) from Account a left join Contacts c on a.Id = c.AccountId", nil)
+	assert.NotNil(t, err)
+
+	_, err = Translate("select * from Account a left join Contacts a on a.Id = a.AccountId", nil)
+	assert.NotNil(t, err)
+
+	_, err = Translate("select * from Account left Contacts", nil)
+	assert.NotNil(t, err)
+}
+
+func TestParseQueryFunctions(t *testing.T) {
+
+	queryObj, err := Translate("select Name from Account where upper(Name) = 'ACME' and year(Created) = 2025 and length(trim(Code)) > 3", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "toupper(Name) eq 'ACME' and year(Created) eq 2025 and length(trim(Code)) gt 3", queryObj.Filter)
+
+	queryObj, err = Translate("select Name from Account where substring(Code, :start, 2) = 'AB' or indexof(tolower(Name), 'inc') >= 0 or round(Amount) != floor(Amount)", map[string]interface{}{"start": 1})
+	assert.Nil(t, err)
+	assert.Equal(t, "substring(Code, 1, 2) eq 'AB' or indexof(tolower(Name), 'inc') ge 0 or round(Amount) ne floor(Amount)", queryObj.Filter)
+
+	// ISO 8601 strings are dates for the date functions
+	params := map[string]interface{}{"d": "2025-01-01", "t": "2025-01-01T10:30:00", "since": time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	queryObj, err = Translate("select Name from Account where year(:d) = year(Created) and hour(:t) < hour('2025-01-01T12:00:00+01:00') and month(:since) = day('2025-01-31')", params)
+	assert.Nil(t, err)
+	assert.Equal(t, "year(2025-01-01) eq year(Created) and hour(2025-01-01T10:30:00Z) lt hour(2025-01-01T12:00:00+01:00) and month(2025-01-01T00:00:00Z) eq day(2025-01-31)", queryObj.Filter)
+
+	_, err = Translate("select Name from Account where year(:d) = 2025", map[string]interface{}{"d": "January"})
+	assert.EqualError(t, err, "invalid query: argument 1 of year must be a date, input param 'd' is string")
+
+	queryObj, err = Translate("select country, count(*) as total from Account where month(Created) = 1 group by country having ceiling(avg(Amount)) > 10", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "filter(month(Created) eq 1)/groupby((country),aggregate($count as total,Amount with average as _having2))", queryObj.Apply)
+	assert.Equal(t, "ceiling(_having2) gt 10", queryObj.Filter)
+
+	queryObj, err = Translate("select Id, upper(Name) as Upper, length(Code) from Account", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Id", "Name", "Code"}, queryObj.Select)
+	assert.Equal(t, []string{"Name", "Code"}, queryObj.computation.hidden)
+
+	results := []interface{}{
+		map[string]interface{}{"Id": 1.0, "Name": "Acme", "Code": "A-1"},
+		map[string]interface{}{"Id": 2.0, "Name": nil, "Code": "B"},
+	}
+	assert.Nil(t, queryObj.computation.apply(results))
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"Id": 1.0, "Upper": "ACME", "length_Code": 3.0},
+		map[string]interface{}{"Id": 2.0, "Upper": nil, "length_Code": 1.0},
+	}, results)
+
+	// invalid
+	_, err = Translate("select Name from Account where upper(Name, 'x') = 'A'", nil)
+	assert.NotNil(t, err)
+
+	_, err = Translate("select Name from Account where length(12) > 1", nil)
+	assert.NotNil(t, err)
+
+	_, err = Translate("select Name from Account where year(Name) = 'x'", nil)
+	assert.NotNil(t, err)
+
+	_, err = Translate("select Name from Account where year('2025-13-01') = 2025", nil)
+	assert.NotNil(t, err)
+
+	_, err = Translate("select Name from Account where substring(Name) = 'x'", nil)
+	assert.NotNil(t, err)
+
+	_, err = Translate("select Name from Account where round(:value) = 1", map[string]interface{}{"value": "x"})
+	assert.NotNil(t, err)
+
+	_, err = Translate("select Name from Account where soundex(Name) = 'x'", nil)
+	assert.NotNil(t, err)
+
+	_, err = Translate("select upper(Name) as u from Account order by u", nil)
+	assert.NotNil(t, err)
+
+	_, err = Translate("select upper(Name) as Name, Name from Account", nil)
+	assert.NotNil(t, err)
+
+	_, err = Translate("select country, upper(country) from Account group by country", nil)
+	assert.NotNil(t, err)
+}
+
+func TestEvalFunction(t *testing.T) {
+
+	created := time.Date(2025, 3, 14, 15, 9, 26, 0, time.UTC)
+
+	tests := []struct {
+		fn       string
+		args     []interface{}
+		expected interface{}
+	}{
+		{"lower", []interface{}{"AbC"}, "abc"},
+		{"trim", []interface{}{"  a b  "}, "a b"},
+		{"length", []interface{}{"héllo"}, 5.0},
+		{"substring", []interface{}{"héllo", 1.0}, "éllo"},
+		{"substring", []interface{}{"héllo", 1.0, 2.0}, "él"},
+		{"substring", []interface{}{"abc", 5.0}, ""},
+		{"indexof", []interface{}{"héllo", "llo"}, 2.0},
+		{"indexof", []interface{}{"abc", "x"}, -1.0},
+		{"year", []interface{}{"2025-03-14T15:09:26Z"}, 2025.0},
+		{"month", []interface{}{"2025-03-14"}, 3.0},
+		{"day", []interface{}{created}, 14.0},
+		{"hour", []interface{}{created}, 15.0},
+		{"round", []interface{}{json.Number("2.5")}, 3.0},
+		{"floor", []interface{}{-1.5}, -2.0},
+		{"ceiling", []interface{}{1.2}, 2.0},
+		{"upper", []interface{}{nil}, nil},
+		{"year", []interface{}{"not a date"}, nil},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, evalFunction(functions[test.fn], test.args), test.fn)
+	}
+}
+
+func TestParseQueryTypedLiterals(t *testing.T) {
+
+	queryObj, err := Translate("select * from Account where modifiedOn > timestamp '2025-01-01T00:00:00Z' and closedOn = date '2025-02-03' and Id = GUID 'c56a4180-65aa-42ec-a945-5fd21dec0538'", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "modifiedOn gt 2025-01-01T00:00:00Z and closedOn eq 2025-02-03 and Id eq c56a4180-65aa-42ec-a945-5fd21dec0538", queryObj.Filter)
+
+	queryObj, err = Translate("select * from Account where createdOn between date '2025-01-01' and timestamp '2025-01-31T23:59:59.5+01:00' and year(timestamp '2025-01-01T00:00:00Z') = 2025", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "(createdOn ge 2025-01-01 and createdOn le 2025-01-31T23:59:59.5+01:00) and year(2025-01-01T00:00:00Z) eq 2025", queryObj.Filter)
+
+	// strings written as timestamps or guids compared with a column are typed,
+	// dates only and strings passed to functions stay strings
+	queryObj, err = Translate("select * from Account where modifiedOn > '2025-01-01T00:00:00Z'", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "modifiedOn gt 2025-01-01T00:00:00Z", queryObj.Filter)
+
+	queryObj, err = Translate("select * from Account where Code = '2025-01-01' and Id in ('c56a4180-65aa-42ec-a945-5fd21dec0538', 'none') and '2025-01-31T00:00:00+01:00' >= closedOn and createdOn between '2025-01-01T00:00:00Z' and '2025-02-01' and length('2025-01-01T00:00:00Z') = 20", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "Code eq '2025-01-01' and (Id eq c56a4180-65aa-42ec-a945-5fd21dec0538 or Id eq 'none') and 2025-01-31T00:00:00+01:00 ge closedOn and (createdOn ge 2025-01-01T00:00:00Z and createdOn le '2025-02-01') and length('2025-01-01T00:00:00Z') eq 20", queryObj.Filter)
+
+	guid := [16]byte{0xc5, 0x6a, 0x41, 0x80, 0x65, 0xaa, 0x42, 0xec, 0xa9, 0x45, 0x5f, 0xd2, 0x1d, 0xec, 0x05, 0x38}
+	params := map[string]interface{}{
+		"id":    guid,
+		"key":   "C56A4180-65AA-42EC-A945-5FD21DEC0538",
+		"since": time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+		"day":   "2025-01-02",
+		"code":  "2025-01-02",
+		"until": time.Date(2025, 1, 31, 10, 0, 0, 0, time.UTC),
+		"none":  nil,
+	}
+	queryObj, err = Translate("select * from Account where Id = :id or Key = guid :key or modifiedOn >= :since or day = date :day or Code = :code or closedOn <= date :until or closedOn = timestamp :none", params)
+	assert.Nil(t, err)
+	assert.Equal(t, "Id eq c56a4180-65aa-42ec-a945-5fd21dec0538 or Key eq C56A4180-65AA-42EC-A945-5FD21DEC0538 or modifiedOn ge 2025-01-02T03:04:05Z or day eq 2025-01-02 or Code eq '2025-01-02' or closedOn le 2025-01-31 or closedOn eq null", queryObj.Filter)
+
+	_, err = Translate("select * from Account where day = date :day", map[string]interface{}{"day": "2025-01-02T00:00:00"})
+	assert.EqualError(t, err, "invalid query: input param 'day' is not a valid value '2025-01-02T00:00:00', expected yyyy-mm-dd")
+
+	_, err = Translate("select * from Account where Id = guid :id", map[string]interface{}{"id": 42})
+	assert.EqualError(t, err, "invalid query: input param 'id' has unsupported type int, expected xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx")
+
+	// timestamps need an offset as OData has no date time without one
+	_, err = Translate("select * from Account where modifiedOn > timestamp '2025-01-01T00:00:00'", nil)
+	queryErr, ok := err.(*QueryError)
+	assert.True(t, ok)
+	assert.Equal(t, 52, queryErr.Column)
+	assert.Equal(t, "'2025-01-01T00:00:00'", queryErr.Token)
+
+	_, err = Translate("select * from Account where closedOn = date '2025-02-30'", nil)
+	queryErr, ok = err.(*QueryError)
+	assert.True(t, ok)
+	assert.Equal(t, "invalid date literal '2025-02-30', expected yyyy-mm-dd", queryErr.Message)
+}
+
+func TestQueryError(t *testing.T) {
+
+	_, err := Translate("select Name from Account where Name = ", nil)
+	queryErr, ok := err.(*QueryError)
+	assert.True(t, ok)
+	assert.Equal(t, 1, queryErr.Line)
+	assert.Equal(t, 39, queryErr.Column)
+	assert.Equal(t, "", queryErr.Token)
+	assert.Equal(t, "invalid query: invalid where clause, unexpected end of query at line 1, column 39\n"+
+		"select Name from Account where Name = \n"+
+		"                                      ^", queryErr.Error())
+
+	_, err = Translate("select Name\nfrom Account\nwhere Name is 'x'", nil)
+	queryErr, ok = err.(*QueryError)
+	assert.True(t, ok)
+	assert.Equal(t, 3, queryErr.Line)
+	assert.Equal(t, 15, queryErr.Column)
+	assert.Equal(t, "'x'", queryErr.Token)
+	assert.Equal(t, []string{kwNull}, queryErr.Expected)
+	assert.Equal(t, "invalid query: null expected after is, found 'x' at line 3, column 15\n"+
+		"where Name is 'x'\n"+
+		"              ^", queryErr.Error())
+
+	_, err = Translate("select a.Name from Account a where b.Status = 'A'", nil)
+	queryErr, ok = err.(*QueryError)
+	assert.True(t, ok)
+	assert.Equal(t, 36, queryErr.Column)
+	assert.Equal(t, "b.Status", queryErr.Token)
+
+	_, err = Translate("select Name from Account where Name = 'x", nil)
+	queryErr, ok = err.(*QueryError)
+	assert.True(t, ok)
+	assert.Equal(t, 39, queryErr.Column)
+	assert.Equal(t, "unterminated string", queryErr.Message)
+
+	_, err = Translate("select Name from Account where length(Name, 1) > 2", nil)
+	queryErr, ok = err.(*QueryError)
+	assert.True(t, ok)
+	assert.Equal(t, 32, queryErr.Column)
+	assert.Equal(t, "length", queryErr.Token)
+
+	// like patterns are checked when parsed
+	likeErrors := []struct {
+		query    string
+		message  string
+		token    string
+		expected []string
+	}{
+		{"select Name from Account where Name like '%a%b%'", "unsupported like pattern '%a%b%', wildcards are only supported at the start or end", "'%a%b%'", nil},
+		{"select Name from Account where Name like 'a_b'", "unsupported like pattern 'a_b', the _ wildcard has no equivalent in OData", "'a_b'", nil},
+		{"select Name from Account where Name like 5", "like requires a string pattern, found '5'", "5", []string{"string", "parameter"}},
+	}
+	for _, test := range likeErrors {
+		_, err = Parse(test.query)
+		queryErr, ok = err.(*QueryError)
+		if assert.True(t, ok, test.query) {
+			assert.Equal(t, test.message, queryErr.Message, test.query)
+			assert.Equal(t, 42, queryErr.Column, test.query)
+			assert.Equal(t, test.token, queryErr.Token, test.query)
+			assert.Equal(t, test.expected, queryErr.Expected, test.query)
+		}
+	}
+
+	// errors found when binding parameters are not located in the query
+	_, err = Translate("select Name from Account where Name = :name", nil)
+	_, ok = err.(*QueryError)
+	assert.False(t, ok)
+}
+
+func TestParseQueryStrict(t *testing.T) {
+
+	tests := []struct {
+		query   string
+		message string
+		column  int
+	}{
+		{"select * from a b c", "unexpected 'c', expected join, expand, where, group by, having, order by, top, skip, limit, offset, fetch or end of query", 19},
+		{"select Name from a orderby x junk", "unexpected 'junk', expected top, skip, limit, offset, fetch or end of query", 30},
+		{"select * from a where x = 1 y = 2", "unexpected 'y', expected and, or, group by, having, order by, top, skip, limit, offset, fetch or end of query", 29},
+		{"select * from a where x = 1 where y = 2", "duplicate where clause", 29},
+		{"select * from a group by x group by y", "duplicate group by clause", 28},
+		{"select * from a from b", "duplicate from clause", 17},
+		{"select * from a expand b join c on a.x = c.y", "duplicate join clause", 26},
+		{"select * from a select", "only one select statement is supported", 17},
+		{"select * from a order by x where y = 1", "where clause must come before order by", 28},
+		{"select * from a limit 1 where x = 1", "where clause must come before limit", 25},
+		{"select * from a where x = 1 join b on a.x = b.y", "join clause must come before where", 29},
+		{"select * from a having count(*) > 1 group by x", "group by clause must come before having", 37},
+		{"select * from a limit 1 order by x", "order by clause must come before limit", 25},
+	}
+
+	for _, test := range tests {
+		_, err := Translate(test.query, nil)
+		queryErr, ok := err.(*QueryError)
+		if assert.True(t, ok, test.query) {
+			assert.Equal(t, test.message, queryErr.Message, test.query)
+			assert.Equal(t, test.column, queryErr.Column, test.query)
+		}
+	}
+}
+
+func TestParseQueryComments(t *testing.T) {
+
+	query := "-- accounts changed today\n" +
+		"select Name,\tCity /* , Phone */\r\n" +
+		"from   Account\n" +
+		"where  Notes = '-- not a comment /* either */' -- trailing comment\n" +
+		"\tand Amount > -1\n" +
+		"order by Name /* multi\nline */"
+
+	queryObj, err := Translate(query, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Name", "City"}, queryObj.Select)
+	assert.Equal(t, "Account", queryObj.Entity)
+	assert.Equal(t, "Notes eq '-- not a comment /* either */' and Amount gt -1", queryObj.Filter)
+	assert.Equal(t, []string{"Name"}, queryObj.OrderBy)
+
+	_, err = Translate("select * from Account /* unterminated", nil)
+	queryErr, ok := err.(*QueryError)
+	assert.True(t, ok)
+	assert.Equal(t, "unterminated comment", queryErr.Message)
+	assert.Equal(t, 23, queryErr.Column)
+
+	// positions in errors account for comments and line breaks
+	_, err = Translate("select * /* all */\nfrom Account\nwhere -- none\n", nil)
+	queryErr, ok = err.(*QueryError)
+	assert.True(t, ok)
+	assert.Equal(t, 4, queryErr.Line)
+	assert.Equal(t, 1, queryErr.Column)
+}
+
+func TestTranslate(t *testing.T) {
+
+	request, err := Translate("select top 5 Name, Code as Ref from Account where Status = :status and Amount > 10 order by Name desc", map[string]interface{}{"status": "A"})
+	assert.Nil(t, err)
+	assert.Equal(t, "Account", request.Entity)
+	assert.Equal(t, []string{"Name", "Code"}, request.Select)
+	assert.Equal(t, "Status eq 'A' and Amount gt 10", request.Filter)
+	assert.Equal(t, []string{"Name desc"}, request.OrderBy)
+	assert.Equal(t, 5, *request.Top)
+	assert.Nil(t, request.Skip)
+	assert.Equal(t, "http://localhost/query/Account?$select=Name%2C+Code&$top=5&$filter=Status+eq+%27A%27+and+Amount+gt+10&$orderby=Name+desc", request.URL("http://localhost/query/"))
+
+	where, ok := request.Where.(*LogicalExpr)
+	assert.True(t, ok)
+	assert.Equal(t, kwAnd, where.Op)
+	assert.Equal(t, &ComparisonExpr{Op: "=", Left: &ColumnRef{Name: "Status", pos: 50}, Right: &Parameter{Name: "status"}}, where.Left)
+
+	assert.False(t, request.ClientSide())
+	assert.Nil(t, request.Fallback())
+
+	results := []interface{}{map[string]interface{}{"Name": "Acme", "Code": "A1"}}
+	assert.Nil(t, request.Complete(results))
+	assert.Equal(t, []interface{}{map[string]interface{}{"Name": "Acme", "Ref": "A1"}}, results)
+
+	// client side
+	request, err = Translate("select distinct * from Account where Status = 'A' top 1 skip 1", nil)
+	assert.Nil(t, err)
+	assert.True(t, request.ClientSide())
+
+	fallback := request.Fallback()
+	assert.Nil(t, fallback.Top)
+	assert.Nil(t, fallback.Skip)
+	assert.Equal(t, "Status eq 'A'", fallback.Filter)
+
+	results, err = request.Evaluate([]interface{}{
+		map[string]interface{}{"Name": "Acme"},
+		map[string]interface{}{"Name": "Acme"},
+		map[string]interface{}{"Name": "Initech"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{map[string]interface{}{"Name": "Initech"}}, results)
+
+	request, err = Translate("select Country, count(Email) from Contact group by Country", nil)
+	assert.Nil(t, err)
+	assert.True(t, request.ClientSide())
+	assert.Equal(t, &ODataRequest{Entity: "Contact", Select: []string{"Country", "Email"}, OrderBy: []string{"Country", "Email"}}, request.Fallback())
+
+	// the fallback reads the rows in a stable order
+	request, err = Translate("select count(*) as total from Contact where Status = 'A'", nil)
+	assert.Nil(t, err)
+	fallback = request.Fallback()
+	assert.Equal(t, []string{"*"}, fallback.Select)
+	assert.Nil(t, fallback.OrderBy)
+	fallback.OrderByRow(map[string]interface{}{"Name": "Acme", "Id": 1.0, "Account": map[string]interface{}{"Id": 2.0}, "Full Name": "x"})
+	assert.Equal(t, "http://localhost/Contact?$select=Id&$filter=Status+eq+%27A%27&$orderby=Id", fallback.URL("http://localhost"))
+
+	request, err = Translate("select distinct * from Contact order by Name", nil)
+	assert.Nil(t, err)
+	fallback = request.Fallback()
+	fallback.OrderByRow(map[string]interface{}{"Name": "Acme", "Id": 1.0, "Code": "A"})
+	assert.Equal(t, []string{"*"}, fallback.Select)
+	assert.Equal(t, []string{"Name", "Code", "Id"}, fallback.OrderBy)
+
+	// the requests bound from a statement are independent
+	stmt, err := Parse("select * from Account order by Name")
+	assert.Nil(t, err)
+	request, err = stmt.Bind(nil)
+	assert.Nil(t, err)
+	request.OrderBy[0] = "Code"
+	request, err = stmt.Bind(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Name"}, request.OrderBy)
+
+	_, err = Translate("select * from", nil)
+	_, ok = err.(*QueryError)
+	assert.True(t, ok)
+}
+
+func TestToSQL(t *testing.T) {
+
+	sql, err := ToSQL("https://localhost/api/connections/1/query/Account?$select=Name%2C+Code&$top=5&$skip=10&$filter=Status+eq+%27A%27+and+%28Amount+gt+-1.5+or+Amount+eq+null%29&$orderby=Name+desc%2C+Code")
+	assert.Nil(t, err)
+	assert.Equal(t, "select top 5 skip 10 Name, Code from Account where Status = 'A' and (Amount > -1.5 or Amount is null) order by Name desc, Code", sql)
+
+	sql, err = ToSQL("/query/Sales%20Order?$filter=contains(Name, 'O''Brien') and not startswith(Code, 'X') and endswith(Code, 'Z') eq false and Active")
+	assert.Nil(t, err)
+	assert.Equal(t, `select * from "Sales Order" where Name like '%O''Brien%' and Code not like 'X%' and Code not like '%Z' and Active = true`, sql)
+
+	sql, err = ToSQL("/query/Order?$filter=Created ge 2024-01-31T00:00:00Z and Day lt 2024-02-01 and Id ne 6f9619ff-8b86-d011-b42d-00c04fc964ff and year(Created) eq 2024 and tolower(From) ne null")
+	assert.Nil(t, err)
+	assert.Equal(t, `select * from "Order" where Created >= timestamp '2024-01-31T00:00:00Z' and Day < date '2024-02-01' and Id <> guid '6f9619ff-8b86-d011-b42d-00c04fc964ff' and year(Created) = 2024 and tolower("From") is not null`, sql)
+
+	sql, err = ToSQL("/query/Account?$select=Name&$expand=Contacts%28%24select%3DEmail%2CPhone%29%2COpportunities")
+	assert.Nil(t, err)
+	assert.Equal(t, "select Name, Contacts.Email, Contacts.Phone from Account expand Contacts, Opportunities", sql)
+
+	// aggregates, select distinct and the filters of expanded entities
+	sql, err = ToSQL("/query/Account?$apply=filter(Status eq 'A')/groupby((Country,City),aggregate($count as total,Amount with average as avg_Amount,Owner with countdistinct as owners,Amount with max as _having4))&$filter=total gt 5 and _having4 lt 100&$orderby=total desc&$top=3")
+	assert.Nil(t, err)
+	assert.Equal(t, "select top 3 Country, City, count(*) as total, avg(Amount) as avg_Amount, count(distinct Owner) as owners from Account where Status = 'A' group by Country, City having count(*) > 5 and max(Amount) < 100 order by total desc", sql)
+
+	sql, err = ToSQL("/query/Account?$apply=aggregate(Amount with sum as total)")
+	assert.Nil(t, err)
+	assert.Equal(t, "select sum(Amount) as total from Account", sql)
+
+	sql, err = ToSQL("/query/Account?$apply=groupby((Country))&$orderby=Country")
+	assert.Nil(t, err)
+	assert.Equal(t, "select distinct Country from Account order by Country", sql)
+
+	sql, err = ToSQL("/query/Account?$select=Name&$expand=Contacts($select=Email%3B$filter=$it/Id eq AccountId and Active eq true),Notes")
+	assert.Nil(t, err)
+	assert.Equal(t, "select Name, Contacts.Email from Account left join Contacts on Account.Id = Contacts.AccountId and Contacts.Active = true expand Notes", sql)
+
+	// requests translated from a query render their parameters
+	request, err := Translate("select * from Account where Status = :status and Amount between 1 and 5", map[string]interface{}{"status": "A"})
+	assert.Nil(t, err)
+	sql, err = request.SQL()
+	assert.Nil(t, err)
+	assert.Equal(t, "select * from Account where Status = :status and Amount between 1 and 5", sql)
+
+	// invalid
+	_, err = ToSQL("/query/Account?$apply=compute(Amount mul 2 as Double)")
+	assert.NotNil(t, err)
+
+	_, err = ToSQL("/query/Account?$apply=groupby((Country))/filter(Country eq 'US')")
+	assert.NotNil(t, err)
+
+	_, err = ToSQL("/query/Account?$apply=aggregate(Amount with stddev as s)")
+	assert.NotNil(t, err)
+
+	_, err = ToSQL("/query/Account?$select=Name&$apply=groupby((Country))")
+	assert.NotNil(t, err)
+
+	_, err = ToSQL("/query/Account?$filter=$it/Id eq 1")
+	assert.NotNil(t, err)
+
+	_, err = ToSQL("/query/Account?$filter=concat(Name, Code) eq 'x'")
+	assert.NotNil(t, err)
+
+	_, err = ToSQL("/query/Account?$filter=contains(Name, '50%')")
+	assert.NotNil(t, err)
+
+	_, err = ToSQL("/query/Account?$filter=Amount gt null")
+	assert.NotNil(t, err)
+
+	_, err = ToSQL("/query/Account?$filter=Name eq 'a' Code")
+	_, ok := err.(*QueryError)
+	assert.True(t, ok)
+
+	_, err = ToSQL("/query/Account?$top=x")
+	assert.NotNil(t, err)
+
+	_, err = ToSQL("/query/Account?$count=true")
+	assert.NotNil(t, err)
+
+	_, err = ToSQL("/query/Account?$select=Name&$expand=Contacts($orderby=Email)")
+	assert.NotNil(t, err)
+
+	_, err = ToSQL("/query/Account?$expand=Contacts($select=Email)")
+	assert.NotNil(t, err)
+}
+
+// TestToSQLRoundTrip checks with random queries that the query rendered from
+// the URL of a translated query translates to the same request
+func TestToSQLRoundTrip(t *testing.T) {
+
+	const base = "http://localhost/connections/1/query"
+
+	g := &queryGenerator{rand: rand.New(rand.NewSource(1))}
+
+	for i := 0; i < 1000; i++ {
+		sql := g.query()
+
+		request, err := Translate(sql, nil)
+		if g.unsupported {
+			// names OData cannot express are rejected
+			_, ok := err.(*QueryError)
+			assert.True(t, ok, sql)
+			continue
+		}
+		if !assert.Nil(t, err, sql) {
+			continue
+		}
+
+		sql2, err := ToSQL(request.URL(base))
+		if !assert.Nil(t, err, sql) {
+			continue
+		}
+
+		request2, err := Translate(sql2, nil)
+		if !assert.Nil(t, err, sql2) {
+			continue
+		}
+
+		sql3, err := ToSQL(request2.URL(base))
+		assert.Nil(t, err, sql2)
+		assert.Equal(t, sql2, sql3, sql)
+
+		assert.Equal(t, request.Entity, request2.Entity, sql)
+		assert.Equal(t, request.Select, request2.Select, sql)
+		assert.Equal(t, request.OrderBy, request2.OrderBy, sql)
+		assert.Equal(t, request.Top, request2.Top, sql)
+		assert.Equal(t, request.Skip, request2.Skip, sql)
+		assert.Equal(t, request.Expand, request2.Expand, sql)
+		assert.Equal(t, normalizeFilter(t, request.Filter), normalizeFilter(t, request2.Filter), sql)
+		assert.Equal(t, normalizeApply(t, request.Apply), normalizeApply(t, request2.Apply), sql)
+	}
+}
+
+// normalizeFilter renders a $filter as a where clause, which only differs
+// between equivalent filters by the parentheses they are written with
+func normalizeFilter(t *testing.T, filter string) string {
+
+	if filter == "" {
+		return ""
+	}
+
+	expr, err := parseFilter(filter)
+	assert.Nil(t, err, filter)
+	return buildSQL(expr)
+}
+
+// normalizeApply renders the filter of an $apply as normalizeFilter does
+func normalizeApply(t *testing.T, apply string) string {
+
+	if apply == "" {
+		return ""
+	}
+
+	a, err := parseApply(apply)
+	assert.Nil(t, err, apply)
+	if a == nil {
+		return apply
+	}
+
+	var aggregates []string
+	for _, column := range a.aggregates {
+		aggregates = append(aggregates, buildSQL(column.expr())+" as "+column.alias)
+	}
+	return fmt.Sprintf("where %s group by %v aggregate %v", buildSQL(a.where), a.groupBy, aggregates)
+}
+
+// queryGenerator generates random queries, unsupported is set when the query
+// uses a name OData cannot express outside of select
+type queryGenerator struct {
+	rand        *rand.Rand
+	unsupported bool
+}
+
+func (g *queryGenerator) pick(values ...string) string {
+	return values[g.rand.Intn(len(values))]
+}
+
+// some picks one to max values in random order
+func (g *queryGenerator) some(max int, values ...string) []string {
+
+	var picked []string
+	for _, i := range g.rand.Perm(len(values))[:g.rand.Intn(max)+1] {
+		picked = append(picked, values[i])
+	}
+	return picked
+}
+
+// column picks a column for a clause other than select, or at times "Order
+// Date", which OData cannot express outside of select
+func (g *queryGenerator) column(columns ...string) string {
+
+	if g.rand.Intn(20) == 0 {
+		g.unsupported = true
+		return `"Order Date"`
+	}
+	return g.pick(columns...)
+}
+
+func (g *queryGenerator) query() string {
+
+	g.unsupported = false
+
+	sql := "select "
+	switch g.rand.Intn(4) {
+	case 0:
+		sql += g.aggregateQuery()
+	case 1:
+		columns := g.some(2, "Country", "City", "Name")
+		sql += "distinct " + g.paging() + strings.Join(columns, ", ") + " from " + g.from() + g.where()
+		if g.rand.Intn(2) == 0 {
+			sql += " order by " + g.pick(columns...) + g.pick("", " desc")
+		}
+	default:
+		sql += g.rowQuery()
+	}
+
+	return sql
+}
+
+func (g *queryGenerator) paging() string {
+
+	paging := ""
+	if g.rand.Intn(3) == 0 {
+		paging += fmt.Sprintf("top %d ", g.rand.Intn(100))
+	}
+	if g.rand.Intn(3) == 0 {
+		paging += fmt.Sprintf("skip %d ", g.rand.Intn(100))
+	}
+	return paging
+}
+
+func (g *queryGenerator) from() string {
+	return g.pick("Account", `"Sales Order"`, "[Select]")
+}
+
+func (g *queryGenerator) where() string {
+
+	if g.rand.Intn(4) == 0 {
+		return ""
+	}
+	return " where " + g.condition(3)
+}
+
+// rowQuery generates a query returning rows, optionally with a related entity
+// read by a left join or expand
+func (g *queryGenerator) rowQuery() string {
+
+	sql := g.paging()
+
+	related := g.rand.Intn(3)
+	columns := []string{"Name", "Amount", `"Order Date"`, `"From"`, "Name as Title", "upper(Name) as Upper"}
+	if related > 0 {
+		// functions cannot be selected with related entities
+		columns = columns[:5]
+	}
+
+	if g.rand.Intn(4) == 0 && related == 0 {
+		sql += "*"
+	} else {
+		items := g.some(3, columns...)
+		if related > 0 && g.rand.Intn(3) > 0 {
+			items = append(items, "Contacts.Email")
+		}
+		sql += strings.Join(items, ", ")
+	}
+
+	from := g.from()
+	sql += " from " + from
+	switch related {
+	case 1:
+		sql += " expand Contacts"
+	case 2:
+		sql += " left join Contacts on Contacts.AccountId = " + from + ".Id"
+		sql += g.pick("", " and Contacts.Active = true", " and Contacts.Created >= "+from+".Created")
+	}
+
+	sql += g.where()
+
+	if g.rand.Intn(2) == 0 {
+		var items []string
+		for n := g.rand.Intn(2) + 1; n > 0; n-- {
+			items = append(items, g.column("Name", "Amount", `"From"`)+g.pick("", " asc", " desc"))
+		}
+		sql += " order by " + strings.Join(items, ", ")
+	}
+
+	return sql
+}
+
+// aggregateQuery generates a query with aggregates, count(column) is left out
+// as it is always computed by the client and never sent to the server
+func (g *queryGenerator) aggregateQuery() string {
+
+	var groupBy []string
+	if g.rand.Intn(4) > 0 {
+		groupBy = g.some(2, "Country", "City")
+	}
+
+	aggregates := []string{"count(*) as total", "sum(Amount)", "avg(Amount) as average", "min(Name)", "max(Created) as latest", "count(distinct Owner) as owners"}
+	// the grouped columns are not always selected
+	var items []string
+	for _, column := range groupBy {
+		if g.rand.Intn(3) > 0 {
+			items = append(items, column)
+		}
+	}
+	items = append(items, g.some(3, aggregates...)...)
+
+	sql := g.paging() + strings.Join(items, ", ") + " from " + g.from() + g.where()
+
+	if len(groupBy) > 0 {
+		sql += " group by " + strings.Join(groupBy, ", ")
+		if g.rand.Intn(2) == 0 {
+			sql += " having " + g.pick("count(*) > 1", "sum(Amount) < 100", "max(Amount) >= 5 and "+groupBy[0]+" <> 'x'", "not (avg(Amount) between 1 and 10)")
+		}
+		if g.rand.Intn(2) == 0 {
+			sql += " order by " + g.column(groupBy...) + g.pick("", " desc")
+		}
+	}
+
+	return sql
+}
+
+func (g *queryGenerator) condition(depth int) string {
+
+	if depth > 0 {
+		switch g.rand.Intn(4) {
+		case 0:
+			return g.condition(depth-1) + g.pick(" and ", " or ") + g.condition(depth-1)
+		case 1:
+			return "(" + g.condition(depth-1) + g.pick(" and ", " or ") + g.condition(depth-1) + ")"
+		case 2:
+			return "not (" + g.condition(depth-1) + ")"
+		}
+	}
+
+	kind := g.rand.Intn(9)
+	if kind == 5 {
+		return g.pick("upper(Name) = 'AB'", "length(trim(Name)) > 3", "year(Created) = 2024", "month('2024-01-31') = 1", "substring(Name, 1, 2) <> 'ab'", "round(Amount) >= floor(Amount)", "indexof(tolower(Name), 'x') = -1")
+	}
+
+	column := g.column("Name", "Amount", "Created", "Id", `"From"`)
+	switch kind {
+	case 0:
+		return column + " in (" + g.value() + ", " + g.value() + ")"
+	case 1:
+		return column + g.pick(" ", " not ") + "in (" + g.value() + ")"
+	case 2:
+		return column + g.pick(" ", " not ") + "between " + g.value() + " and " + g.value()
+	case 3:
+		return column + g.pick(" ", " not ") + "like " + g.pick("'ab%'", "'%ab'", "'%a''b%'", "'ab'", "'%'")
+	case 4:
+		return column + g.pick(" is null", " is not null")
+	}
+	return column + " " + g.pick("=", "<>", "!=", ">", ">=", "<", "<=") + " " + g.value()
+}
+
+func (g *queryGenerator) value() string {
+	return g.pick("'a'", "'O''Brien'", "1", "-2.5", "true", "false", "'2024-01-31'", "date '2024-01-31'", "timestamp '2024-01-31T10:00:00+01:00'", "guid '6f9619ff-8b86-d011-b42d-00c04fc964ff'")
+}